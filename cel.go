@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"github.com/google/cel-go/cel"
+
+	"github.com/crossplane/function-sdk-go/resource"
+
+	"github.com/crossplane-contrib/function-extra-resources/input/v1beta1"
+)
+
+// Evaluate a compiled CEL expression against the given variables.
+func evalCELExpression(prg cel.Program, vars map[string]any) (bool, error) {
+	out, _, err := prg.Eval(vars)
+	if err != nil {
+		return false, errors.Wrap(err, "cannot evaluate CEL expression")
+	}
+	b, ok := out.Value().(bool)
+	if !ok {
+		return false, errors.Errorf("CEL expression evaluated to %T, not a bool", out.Value())
+	}
+	return b, nil
+}
+
+// Keep only the extra resources the filter expression evaluates to true for.
+func filterExtrasByExpression(extras []resource.Required, expr string, xr *resource.Composite) ([]resource.Required, error) {
+	prg, err := v1beta1.CompileCELExpression(expr)
+	if err != nil {
+		return nil, err
+	}
+	filtered := make([]resource.Required, 0, len(extras))
+	for _, e := range extras {
+		ok, err := evalCELExpression(prg, map[string]any{
+			v1beta1.CELVarObject: e.Resource.Object,
+			v1beta1.CELVarXR:     xr.Resource.Object,
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot filter extra resource %q", e.Resource.GetName())
+		}
+		if ok {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered, nil
+}
@@ -3,13 +3,29 @@ package main
 import (
 	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
 	"reflect"
+	"regexp"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
 	"github.com/crossplane/crossplane-runtime/v2/pkg/fieldpath"
 	"github.com/crossplane/crossplane-runtime/v2/pkg/logging"
+	"golang.org/x/mod/semver"
 	"google.golang.org/protobuf/types/known/structpb"
+	k8sresource "k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/validation"
 
 	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
 	"github.com/crossplane/function-sdk-go/request"
@@ -19,6 +35,16 @@ import (
 	"github.com/crossplane-contrib/function-extra-resources/input/v1beta1"
 )
 
+const (
+	// conditionTypeResolved is the type of the composite resource condition
+	// reporting whether all extra resources were resolved.
+	conditionTypeResolved = "ExtraResourcesResolved"
+
+	reasonResolved           = "Resolved"
+	reasonResolutionFailed   = "ResolutionFailed"
+	reasonOptionalUnresolved = "OptionalResourcesUnresolved"
+)
+
 // Function returns whatever response you ask it to.
 type Function struct {
 	fnv1.UnimplementedFunctionRunnerServiceServer
@@ -35,24 +61,20 @@ func (f *Function) RunFunction(_ context.Context, req *fnv1.RunFunctionRequest)
 	// Get function input.
 	in := &v1beta1.Input{}
 	if err := request.GetInput(req, in); err != nil {
-		response.Fatal(rsp, errors.Errorf("cannot get Function input from %T: %w", req, err))
+		fatalUnresolved(rsp, errors.Errorf("cannot get Function input from %T: %w", req, err))
 		return rsp, nil
 	}
-
-	// Get XR the pipeline targets.
-	oxr, err := request.GetObservedCompositeResource(req)
-	if err != nil {
-		response.Fatal(rsp, errors.Errorf("cannot get observed composite resource: %w", err))
+	if err := in.Validate(); err != nil {
+		fatalUnresolved(rsp, errors.Wrap(err, "invalid Function input"))
 		return rsp, nil
 	}
 
-	// Build extraResource Requests.
-	requirements, err := buildRequirements(in, oxr)
+	// Get XR the pipeline targets.
+	oxr, err := request.GetObservedCompositeResource(req)
 	if err != nil {
-		response.Fatal(rsp, errors.Errorf("could not build extra resource requirements: %w", err))
+		fatalUnresolved(rsp, errors.Errorf("cannot get observed composite resource: %w", err))
 		return rsp, nil
 	}
-	rsp.Requirements = requirements
 
 	// The request response cycle for the Crossplane ExtraResources API requires that function-extra-resources
 	// tells Crossplane what it wants.
@@ -60,194 +82,1302 @@ func (f *Function) RunFunction(_ context.Context, req *fnv1.RunFunctionRequest)
 	//
 	// function-extra-resources does not know if it has requested the resources already or not.
 	//
-	// If it has and these resources are now present, proceed with verification and conversion.
-	if req.RequiredResources == nil {
-		f.log.Debug("No extra resources present, exiting", "requirements", rsp.GetRequirements())
+	// If it has and these resources are now present, proceed with verification and conversion. Sources drawing
+	// values from the resources of other sources can only be requested once those are present, so resolving them
+	// takes one more round trip per level of dependencies.
+	extraResources, err := request.GetRequiredResources(req)
+	if err != nil {
+		fatalUnresolved(rsp, errors.Errorf("fetching extra resources %T: %w", req, err))
 		return rsp, nil
 	}
 
-	// Pull extra resources from the ExtraResources request field.
-	extraResources, err := request.GetRequiredResources(req)
+	// Build extraResource Requests, then sort and verify min/max selected.
+	// Sorting is required for determinism.
+	requirements, verifiedExtras, complete, err := resolveExtras(rsp, in, oxr, extraResources, req.RequiredResources != nil)
+	rsp.Requirements = requirements
 	if err != nil {
-		response.Fatal(rsp, errors.Errorf("fetching extra resources %T: %w", req, err))
+		fatalUnresolved(rsp, err)
+		return rsp, nil
+	}
+	if !complete {
+		f.log.Debug("Not all extra resources present, exiting", "requirements", rsp.GetRequirements())
 		return rsp, nil
 	}
 
-	// Sort and verify min/max selected.
-	// Sorting is required for determinism.
-	verifiedExtras, err := verifyAndSortExtras(in, extraResources)
+	if err := writeToComposite(req, rsp, in, verifiedExtras); err != nil {
+		fatalUnresolved(rsp, errors.Errorf("writing extra resources to composite resource: %w", err))
+		return rsp, nil
+	}
+
+	output, err := buildOutput(in, verifiedExtras)
 	if err != nil {
-		response.Fatal(rsp, errors.Errorf("verifying and sorting extra resources: %w", err))
+		fatalUnresolved(rsp, errors.Errorf("building extra resources output: %w", err))
 		return rsp, nil
 	}
 
-	s, err := structpb.NewStruct(verifiedExtras)
+	out, err := mergeIntoContext(req, in.Spec.Context, output)
 	if err != nil {
-		response.Fatal(rsp, errors.Wrapf(err, "cannot create new Struct from extra resources output"))
+		fatalUnresolved(rsp, errors.Wrapf(err, "cannot write extra resources to context key %q", in.Spec.Context.GetKey()))
+		return rsp, nil
+	}
+
+	s, err := structpb.NewStruct(out)
+	if err != nil {
+		fatalUnresolved(rsp, errors.Wrapf(err, "cannot create new Struct from extra resources output"))
 		return rsp, nil
 	}
 	response.SetContextKey(rsp, in.Spec.Context.GetKey(), structpb.NewStructValue(s))
 
+	// Optional sources that could not be resolved are reported as warnings.
+	if hasWarnings(rsp) {
+		response.ConditionFalse(rsp, conditionTypeResolved, reasonOptionalUnresolved)
+		return rsp, nil
+	}
+	response.ConditionTrue(rsp, conditionTypeResolved, reasonResolved)
+
 	return rsp, nil
 }
 
-// Build requirements takes input and outputs an array of external resoruce requirements to request
-// from Crossplane's external resource API.
-func buildRequirements(in *v1beta1.Input, xr *resource.Composite) (*fnv1.Requirements, error) { //nolint:gocyclo,gocognit // Adding non-nil validations increases function complexity.
-	extraResources := make(map[string]*fnv1.ResourceSelector, len(in.Spec.ExtraResources))
-	for _, extraResource := range in.Spec.ExtraResources {
-		extraResName := extraResource.Into
-		switch extraResource.Type {
-		case v1beta1.ResourceSourceTypeReference, "":
-			extraResources[extraResName] = &fnv1.ResourceSelector{
-				ApiVersion: extraResource.APIVersion,
-				Kind:       extraResource.Kind,
-				Match: &fnv1.ResourceSelector_MatchName{
-					MatchName: extraResource.Ref.Name,
-				},
-				Namespace: extraResource.Namespace,
-			}
-		case v1beta1.ResourceSourceTypeSelector:
-			matchLabels := map[string]string{}
-			for _, selector := range extraResource.Selector.MatchLabels {
-				switch selector.GetType() {
-				case v1beta1.ResourceSourceSelectorLabelMatcherTypeValue:
-					if selector.Value == nil {
-						return nil, errors.New("Value cannot be nil for type 'Value'")
-					}
-					matchLabels[selector.Key] = *selector.Value
-				case v1beta1.ResourceSourceSelectorLabelMatcherTypeFromCompositeFieldPath:
-					if selector.ValueFromFieldPath == nil {
-						return nil, errors.New("ValueFromFieldPath cannot be nil for type 'FromCompositeFieldPath'")
-					}
-					value, err := fieldpath.Pave(xr.Resource.Object).GetString(*selector.ValueFromFieldPath)
-					if err != nil {
-						if !selector.FromFieldPathIsOptional() {
-							return nil, errors.Wrapf(err, "cannot get value from field path %q", *selector.ValueFromFieldPath)
+// Add a fatal result to the response and report that the extra resources
+// could not be resolved.
+func fatalUnresolved(rsp *fnv1.RunFunctionResponse, err error) {
+	response.Fatal(rsp, err)
+	response.ConditionFalse(rsp, conditionTypeResolved, reasonResolutionFailed).WithMessage(err.Error())
+}
+
+// Returns true if any warning result was added to the response.
+func hasWarnings(rsp *fnv1.RunFunctionResponse) bool {
+	for _, r := range rsp.GetResults() {
+		if r.GetSeverity() == fnv1.Severity_SEVERITY_WARNING {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge resolved extra resources into the object already stored at the
+// context key, as specified by the context mode.
+func mergeIntoContext(req *fnv1.RunFunctionRequest, c *v1beta1.Context, extras map[string]any) (map[string]any, error) {
+	mode := c.GetMode()
+	if mode == v1beta1.ContextModeReplace {
+		return extras, nil
+	}
+	v, ok := request.GetContextKey(req, c.GetKey())
+	if !ok {
+		return extras, nil
+	}
+	existing, ok := v.AsInterface().(map[string]any)
+	if !ok {
+		return nil, errors.Errorf("cannot merge into existing value of type %T", v.AsInterface())
+	}
+	switch mode { //nolint:exhaustive // Replace is handled above.
+	case v1beta1.ContextModeMergeShallow:
+		maps.Copy(existing, extras)
+		return existing, nil
+	case v1beta1.ContextModeMergeDeep:
+		return mergeDeep(existing, extras), nil
+	default:
+		return nil, errors.Errorf("unsupported context mode %q", mode)
+	}
+}
+
+// Recursively merge src into dst. Objects are merged, any other value in src
+// overwrites the one in dst.
+func mergeDeep(dst, src map[string]any) map[string]any {
+	for k, sv := range src {
+		sm, sok := sv.(map[string]any)
+		dm, dok := dst[k].(map[string]any)
+		if sok && dok {
+			dst[k] = mergeDeep(dm, sm)
+			continue
+		}
+		dst[k] = sv
+	}
+	return dst
+}
+
+// Resolve the extra resources of all sources. The requirement of a source is
+// built once the sources it depends on are resolved, and its resources are
+// verified and sorted once Crossplane has supplied them. Returns false if the
+// resources of any source are still pending. All sources being skipped is
+// complete even if nothing was requested yet. Errors of individual sources are
+// reported as warnings and returned together once no more progress is made. If
+// the requirements of any source could not be built no requirements are
+// returned. The input must be valid, so that sources only depend on other
+// existing sources and there are no cycles between them.
+func resolveExtras(rsp *fnv1.RunFunctionResponse, in *v1beta1.Input, xr *resource.Composite, extraResources map[string][]resource.Required, requested bool) (*fnv1.Requirements, map[string][]resource.Required, bool, error) { //nolint:gocyclo // staged resolution is inherently branchy
+	requirements := &fnv1.Requirements{Resources: make(map[string]*fnv1.ResourceSelector, len(in.Spec.ExtraResources))}
+	verified := make(map[string][]resource.Required)
+	built := make(map[string]bool, len(in.Spec.ExtraResources))
+	done := make(map[string]bool, len(in.Spec.ExtraResources))
+	var buildErrs, verifyErrs sourceErrors
+
+	for progress := true; progress; {
+		progress = false
+
+		// Build extraResource Requests of every source whose dependencies
+		// are resolved.
+		for i := range in.Spec.ExtraResources {
+			src := &in.Spec.ExtraResources[i]
+			if built[src.Into] || !dependenciesDone(src, done) {
+				continue
+			}
+			built[src.Into] = true
+			ok, err := evaluateCondition(src.When, xr)
+			if err != nil {
+				buildErrs = append(buildErrs, newSourceError(rsp, i, src, errors.Wrap(err, "cannot evaluate condition")))
+				continue
+			}
+			if !ok {
+				// Sources whose condition does not hold are never
+				// requested.
+				response.Normal(rsp, fmt.Sprintf("Skipped extra resource %q, its condition does not hold", src.Into))
+				done[src.Into] = true
+				progress = true
+				continue
+			}
+			if hasCandidates(src) {
+				sels, err := buildCandidateRequirements(src, xr)
+				if err != nil {
+					buildErrs = append(buildErrs, newSourceError(rsp, i, src, err))
+					continue
+				}
+				maps.Copy(requirements.Resources, sels)
+				continue
+			}
+			sel, err := buildRequirement(src, xr, verified)
+			if err != nil {
+				buildErrs = append(buildErrs, newSourceError(rsp, i, src, err))
+				continue
+			}
+			if sel != nil {
+				requirements.Resources[src.Into] = sel
+			}
+		}
+
+		// Sort and verify min/max selected of every requested source whose
+		// resources are present.
+		for i := range in.Spec.ExtraResources {
+			src := &in.Spec.ExtraResources[i]
+			if !built[src.Into] || done[src.Into] || buildErrs.has(src.Into) || verifyErrs.has(src.Into) {
+				continue
+			}
+			resources, ok := extraResources[src.Into]
+			_, isRequested := requirements.Resources[src.Into]
+			if hasCandidates(src) {
+				resources, isRequested, ok = getCandidateExtras(src, requirements, extraResources)
+			}
+			if !ok {
+				switch {
+				case !isRequested:
+					// References with an optional name that could not be
+					// resolved and skipped empty selectors are never
+					// requested. Skipped selectors match nothing, which
+					// must still satisfy their MinMatch.
+					if src.GetType() == v1beta1.ResourceSourceTypeSelector {
+						if _, err := verifyMinMatch(rsp, in, src, 0); err != nil {
+							verifyErrs = append(verifyErrs, newSourceError(rsp, i, src, err))
+							continue
 						}
-						continue
 					}
-					matchLabels[selector.Key] = value
+					response.Normal(rsp, fmt.Sprintf("Skipped extra resource %q, nothing to request", src.Into))
+					done[src.Into] = true
+					progress = true
+					continue
+				case !requested || (isRequested && len(src.GetDependencies()) > 0):
+					// Not requested from Crossplane yet.
+					continue
 				}
+				verifyErrs = append(verifyErrs, newSourceError(rsp, i, src, errors.New("cannot find expected extra resource")))
+				continue
 			}
-			if len(matchLabels) == 0 {
+			resources, keep, err := verifyAndSortExtras(rsp, in, src, xr, resources)
+			if err != nil {
+				verifyErrs = append(verifyErrs, newSourceError(rsp, i, src, err))
 				continue
 			}
-			extraResources[extraResName] = &fnv1.ResourceSelector{
-				ApiVersion: extraResource.APIVersion,
-				Kind:       extraResource.Kind,
-				Match: &fnv1.ResourceSelector_MatchLabels{
-					MatchLabels: &fnv1.MatchLabels{Labels: matchLabels},
-				},
-				Namespace: extraResource.Namespace,
+			done[src.Into] = true
+			progress = true
+			if keep {
+				verified[src.Into] = resources
+			}
+		}
+	}
+	if len(buildErrs) > 0 {
+		return nil, nil, false, errors.Errorf("could not build extra resource requirements: %w", append(buildErrs, verifyErrs...))
+	}
+	if len(verifyErrs) > 0 {
+		return requirements, nil, false, errors.Errorf("verifying and sorting extra resources: %w", verifyErrs)
+	}
+	// Crossplane does not call the Function again if it requires nothing, and
+	// sends no required resources at all if it required an empty set of them.
+	complete := len(done) == len(in.Spec.ExtraResources) && (requested || len(requirements.Resources) == 0)
+	return requirements, verified, complete, nil
+}
+
+// A sourceError is an error resolving the extra resources of the source at
+// an index of the input.
+type sourceError struct {
+	index int
+	into  string
+	err   error
+}
+
+func (e *sourceError) Error() string {
+	return fmt.Sprintf("extraResources[%d] (%q): %s", e.index, e.into, e.err)
+}
+
+func (e *sourceError) Unwrap() error {
+	return e.err
+}
+
+// Create an error of the source at the given index and report it as a
+// warning.
+func newSourceError(rsp *fnv1.RunFunctionResponse, index int, src *v1beta1.ResourceSource, err error) *sourceError {
+	e := &sourceError{index: index, into: src.Into, err: err}
+	response.Warning(rsp, e)
+	return e
+}
+
+// sourceErrors are the errors of all sources that could not be resolved.
+type sourceErrors []*sourceError
+
+func (e sourceErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	if len(e) == 1 {
+		return msgs[0]
+	}
+	return fmt.Sprintf("%d extra resources failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+func (e sourceErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Returns true if the source with the given into key failed.
+func (e sourceErrors) has(into string) bool {
+	return slices.ContainsFunc(e, func(err *sourceError) bool { return err.into == into })
+}
+
+// Returns true if the source is a reference to a list of candidate names.
+func hasCandidates(src *v1beta1.ResourceSource) bool {
+	return src.GetType() == v1beta1.ResourceSourceTypeReference && src.Ref.GetType() == v1beta1.ResourceSourceReferenceTypeCandidates
+}
+
+// Build a requirement for every candidate of a reference whose name can be
+// resolved, keyed by their candidate key.
+func buildCandidateRequirements(src *v1beta1.ResourceSource, xr *resource.Composite) (map[string]*fnv1.ResourceSelector, error) {
+	namespace, err := getNamespace(src, xr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot resolve namespace of extra resource %q", src.Into)
+	}
+	optional := v1beta1.FromFieldPathPolicyOptional
+	sels := make(map[string]*fnv1.ResourceSelector, len(src.Ref.Candidates))
+	for i, c := range src.Ref.Candidates {
+		name, resolved, err := getReferenceName(&v1beta1.ResourceSourceReference{
+			Type:                c.Type,
+			Name:                c.Name,
+			NameFromFieldPath:   c.NameFromFieldPath,
+			NameTemplate:        c.NameTemplate,
+			FromFieldPathPolicy: &optional,
+		}, xr, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot resolve candidate %d of extra resource %q", i, src.Into)
+		}
+		if !resolved {
+			continue
+		}
+		sels[src.GetCandidateKey(i)] = &fnv1.ResourceSelector{
+			ApiVersion: src.APIVersion,
+			Kind:       src.Kind,
+			Match: &fnv1.ResourceSelector_MatchName{
+				MatchName: name,
+			},
+			Namespace: namespace,
+		}
+	}
+	return sels, nil
+}
+
+// Get the resources of the first requested candidate of a reference that
+// exists. Returns whether any candidate was requested, and false if the
+// resources of a requested candidate are not present yet.
+func getCandidateExtras(src *v1beta1.ResourceSource, requirements *fnv1.Requirements, extraResources map[string][]resource.Required) ([]resource.Required, bool, bool) {
+	requested := false
+	for i := range src.Ref.Candidates {
+		key := src.GetCandidateKey(i)
+		if _, ok := requirements.Resources[key]; !ok {
+			continue
+		}
+		requested = true
+		resources, ok := extraResources[key]
+		if !ok {
+			return nil, true, false
+		}
+		if len(resources) > 0 {
+			return resources, true, true
+		}
+	}
+	// Either none of the candidates exist, or none could be requested, in
+	// which case verification fails unless the source is optional.
+	return nil, requested, true
+}
+
+// Evaluate the condition of a source against the composite resource. A source
+// without a condition is always requested.
+func evaluateCondition(c *v1beta1.ResourceSourceCondition, xr *resource.Composite) (bool, error) {
+	if c == nil {
+		return true, nil
+	}
+	switch c.Type {
+	case v1beta1.ResourceSourceConditionTypeFieldPathExists, v1beta1.ResourceSourceConditionTypeFieldPathEquals:
+		if c.FieldPath == nil {
+			return false, errors.Errorf("FieldPath cannot be nil for type '%s'", c.Type)
+		}
+		v, err := fieldpath.Pave(xr.Resource.Object).GetValue(*c.FieldPath)
+		if fieldpath.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, errors.Wrapf(err, "cannot get value from field path %q", *c.FieldPath)
+		}
+		if c.Type == v1beta1.ResourceSourceConditionTypeFieldPathExists {
+			return true, nil
+		}
+		if c.Value == nil {
+			return false, errors.New("Value cannot be nil for type 'FieldPathEquals'")
+		}
+		switch v.(type) {
+		case string, bool, float64, int64:
+			return fmt.Sprint(v) == *c.Value, nil
+		default:
+			return false, errors.Errorf("cannot compare value of type %T at field path %q", v, *c.FieldPath)
+		}
+	case v1beta1.ResourceSourceConditionTypeExpression:
+		if c.Expression == nil {
+			return false, errors.New("Expression cannot be nil for type 'Expression'")
+		}
+		prg, err := v1beta1.CompileCELExpression(*c.Expression)
+		if err != nil {
+			return false, err
+		}
+		return evalCELExpression(prg, map[string]any{v1beta1.CELVarXR: xr.Resource.Object})
+	default:
+		return false, errors.Errorf("unsupported condition type %q", c.Type)
+	}
+}
+
+// Returns true if all sources the given source depends on are resolved.
+func dependenciesDone(src *v1beta1.ResourceSource, done map[string]bool) bool {
+	for _, d := range src.GetDependencies() {
+		if !done[d] {
+			return false
+		}
+	}
+	return true
+}
+
+// Get the object of the first resource another source resolved to. Returns
+// false if that source resolved to no resources.
+func getExtraResourceObject(into string, verified map[string][]resource.Required) (map[string]any, bool) {
+	resources := verified[into]
+	if len(resources) == 0 {
+		return nil, false
+	}
+	return resources[0].Resource.Object, true
+}
+
+// Build the requirement of a single source, drawing values from the composite
+// resource or the resolved resources of other sources. Returns nil if the
+// source should not be requested.
+func buildRequirement(src *v1beta1.ResourceSource, xr *resource.Composite, verified map[string][]resource.Required) (*fnv1.ResourceSelector, error) { //nolint:gocyclo,gocognit // Adding non-nil validations increases function complexity.
+	extraResName := src.Into
+	namespace, err := getNamespace(src, xr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot resolve namespace of extra resource %q", extraResName)
+	}
+	switch src.Type {
+	case v1beta1.ResourceSourceTypeReference, "":
+		name, resolved, err := getReferenceName(src.Ref, xr, verified)
+		if err != nil {
+			return nil, err
+		}
+		if !resolved {
+			return nil, nil
+		}
+		return &fnv1.ResourceSelector{
+			ApiVersion: src.APIVersion,
+			Kind:       src.Kind,
+			Match: &fnv1.ResourceSelector_MatchName{
+				MatchName: name,
+			},
+			Namespace: namespace,
+		}, nil
+	case v1beta1.ResourceSourceTypeSelector:
+		matchAll := &fnv1.ResourceSelector{
+			ApiVersion: src.APIVersion,
+			Kind:       src.Kind,
+			Match: &fnv1.ResourceSelector_MatchLabels{
+				MatchLabels: &fnv1.MatchLabels{Labels: map[string]string{}},
+			},
+			Namespace: namespace,
+		}
+		if src.Selector.MatchAll {
+			return matchAll, nil
+		}
+		matchLabels := map[string]string{}
+		for i := range src.Selector.MatchLabels {
+			selector := &src.Selector.MatchLabels[i]
+			value, ok, err := getLabelValue(selector, xr, verified)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			value, err = transformLabelValue(value, selector.Transforms)
+			if err != nil {
+				return nil, errors.Wrapf(err, "cannot transform value of label %q", selector.Key)
+			}
+			if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+				return nil, errors.Errorf("invalid value %q of label %q: %s", value, selector.Key, strings.Join(errs, "; "))
+			}
+			matchLabels[selector.Key] = value
+		}
+		expressions, err := buildLabelSelector(src.Selector, xr)
+		if err != nil {
+			return nil, err
+		}
+		reqs, _ := expressions.Requirements()
+		for _, r := range reqs {
+			// Crossplane can only select extra resources by label
+			// equality, so only expressions allowing a single value can
+			// narrow down the request. The rest are evaluated once the
+			// resources have been fetched.
+			if r.Operator() != selection.In || r.Values().Len() != 1 {
+				continue
+			}
+			if _, ok := matchLabels[r.Key()]; !ok {
+				matchLabels[r.Key()] = r.Values().UnsortedList()[0]
+			}
+		}
+		if len(matchLabels) == 0 && expressions.Empty() {
+			switch p := src.Selector.GetOnEmptySelector(); p {
+			case v1beta1.OnEmptySelectorSkipSource:
+				return nil, nil
+			case v1beta1.OnEmptySelectorMatchAll:
+				return matchAll, nil
+			case v1beta1.OnEmptySelectorError:
+				return nil, errors.Errorf("selector of extra resource %q has no labels to match, set matchAll to select all resources of its kind", extraResName)
+			default:
+				return nil, errors.Errorf("unsupported onEmptySelector policy %q", p)
+			}
+		}
+		return &fnv1.ResourceSelector{
+			ApiVersion: src.APIVersion,
+			Kind:       src.Kind,
+			Match: &fnv1.ResourceSelector_MatchLabels{
+				MatchLabels: &fnv1.MatchLabels{Labels: matchLabels},
+			},
+			Namespace: namespace,
+		}, nil
+	}
+	return nil, nil
+}
+
+// Get the namespace in which to look for an extra resource. Returns nil for
+// cluster scoped resources.
+func getNamespace(src *v1beta1.ResourceSource, xr *resource.Composite) (*string, error) {
+	ns := src.NamespaceFrom
+	if ns == nil {
+		return src.Namespace, nil
+	}
+	var namespace string
+	switch ns.GetType() {
+	case v1beta1.ResourceSourceNamespaceTypeValue:
+		if ns.Value == nil {
+			return nil, errors.New("Value cannot be nil for type 'Value'")
+		}
+		namespace = *ns.Value
+	case v1beta1.ResourceSourceNamespaceTypeFromCompositeFieldPath:
+		if ns.ValueFromFieldPath == nil {
+			return nil, errors.New("ValueFromFieldPath cannot be nil for type 'FromCompositeFieldPath'")
+		}
+		v, err := fieldpath.Pave(xr.Resource.Object).GetString(*ns.ValueFromFieldPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot get value from field path %q", *ns.ValueFromFieldPath)
+		}
+		namespace = v
+	case v1beta1.ResourceSourceNamespaceTypeXRNamespace:
+		namespace = xr.Resource.GetNamespace()
+		if namespace == "" {
+			return nil, errors.New("composite resource is cluster scoped, it has no namespace to look in")
+		}
+	default:
+		return nil, errors.Errorf("unsupported namespace type %q", ns.Type)
+	}
+	if namespace == "" {
+		return nil, errors.Errorf("%s namespace cannot be empty", ns.GetType())
+	}
+	return &namespace, nil
+}
+
+// Get the name of a referenced extra resource. Returns false if the name is
+// drawn from an optional field path that is not set.
+func getReferenceName(ref *v1beta1.ResourceSourceReference, xr *resource.Composite, verified map[string][]resource.Required) (string, bool, error) {
+	if ref == nil {
+		return "", false, errors.New("Ref cannot be nil for type 'Reference'")
+	}
+	switch ref.GetType() {
+	case v1beta1.ResourceSourceReferenceTypeName:
+		return ref.Name, true, nil
+	case v1beta1.ResourceSourceReferenceTypeFromCompositeFieldPath:
+		if ref.NameFromFieldPath == nil {
+			return "", false, errors.New("NameFromFieldPath cannot be nil for type 'FromCompositeFieldPath'")
+		}
+		name, err := fieldpath.Pave(xr.Resource.Object).GetString(*ref.NameFromFieldPath)
+		if err != nil {
+			if ref.FromFieldPathIsOptional() {
+				return "", false, nil
+			}
+			return "", false, errors.Wrapf(err, "cannot get name from field path %q", *ref.NameFromFieldPath)
+		}
+		return name, true, nil
+	case v1beta1.ResourceSourceReferenceTypeFromExtraResourceFieldPath:
+		if ref.NameFromFieldPath == nil {
+			return "", false, errors.New("NameFromFieldPath cannot be nil for type 'FromExtraResourceFieldPath'")
+		}
+		obj, ok := getExtraResourceObject(ref.FromExtraResource, verified)
+		if !ok {
+			if ref.FromFieldPathIsOptional() {
+				return "", false, nil
+			}
+			return "", false, errors.Errorf("cannot get name from extra resource %q: it resolved to no resources", ref.FromExtraResource)
+		}
+		name, err := fieldpath.Pave(obj).GetString(*ref.NameFromFieldPath)
+		if err != nil {
+			if ref.FromFieldPathIsOptional() {
+				return "", false, nil
+			}
+			return "", false, errors.Wrapf(err, "cannot get name from field path %q of extra resource %q", *ref.NameFromFieldPath, ref.FromExtraResource)
+		}
+		return name, true, nil
+	case v1beta1.ResourceSourceReferenceTypeTemplate:
+		if ref.NameTemplate == nil {
+			return "", false, errors.New("NameTemplate cannot be nil for type 'Template'")
+		}
+		name, err := renderNameTemplate(*ref.NameTemplate, xr)
+		if err != nil {
+			if errors.Is(err, errMissingTemplateValue) && ref.FromFieldPathIsOptional() {
+				return "", false, nil
+			}
+			return "", false, errors.Wrapf(err, "cannot render name template %q", *ref.NameTemplate)
+		}
+		return name, true, nil
+	default:
+		return "", false, errors.Errorf("unsupported reference type %q", ref.Type)
+	}
+}
+
+// errMissingTemplateValue is returned when a name template refers to a field
+// the composite resource does not have.
+var errMissingTemplateValue = errors.New("name template refers to a missing field")
+
+// Render a name template against the composite resource. Fields the composite
+// resource does not have, or that render as an empty name, are reported as
+// errMissingTemplateValue.
+func renderNameTemplate(tmpl string, xr *resource.Composite) (string, error) {
+	t, err := template.New("name").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot parse template")
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, xr.Resource.Object); err != nil {
+		var execErr template.ExecError
+		if errors.As(err, &execErr) && strings.Contains(err.Error(), "map has no entry for key") {
+			return "", errors.Wrap(errMissingTemplateValue, err.Error())
+		}
+		return "", errors.Wrap(err, "cannot execute template")
+	}
+	if b.Len() == 0 {
+		return "", errMissingTemplateValue
+	}
+	return b.String(), nil
+}
+
+// Verify Min/Max and sort the extra resources of a source by field path within
+// a single kind. Optional sources that cannot be satisfied are reported as
+// warnings. Returns false if the source should be left out of the output.
+func verifyAndSortExtras(rsp *fnv1.RunFunctionResponse, in *v1beta1.Input, src *v1beta1.ResourceSource, xr *resource.Composite, resources []resource.Required, //nolint:gocyclo // TODO(reedjosh): refactor
+) ([]resource.Required, bool, error) {
+	extraResName := src.Into
+	if src.Filter != nil {
+		filtered, err := filterExtrasByExpression(resources, *src.Filter, xr)
+		if err != nil {
+			return nil, false, errors.Wrapf(err, "cannot filter extra resources %q", extraResName)
+		}
+		resources = filtered
+	}
+	switch src.GetType() {
+	case v1beta1.ResourceSourceTypeReference:
+		if len(resources) == 0 {
+			if src.IsResolutionPolicyOptional(in.Spec.Policy) {
+				response.Warning(rsp, errors.Errorf("Optional extra resource %q not found", extraResName))
+				return nil, false, nil
 			}
+			return nil, false, errors.Errorf("Required extra resource %q not found", extraResName)
+		}
+		if len(resources) > 1 {
+			return nil, false, errors.Errorf("expected exactly one extra resource %q, got %d", extraResName, len(resources))
+		}
+		response.Normal(rsp, fmt.Sprintf("Resolved extra resource %q to %s %q", extraResName, resources[0].Resource.GetKind(), resources[0].Resource.GetName()))
+
+	case v1beta1.ResourceSourceTypeSelector:
+		selector := src.Selector
+		expressions, err := buildLabelSelector(selector, xr)
+		if err != nil {
+			return nil, false, err
+		}
+		resources = filterExtrasByLabels(resources, expressions)
+		keys := slices.Clone(selector.GetSortBy())
+		for i := range keys {
+			keys[i].SortAs = selector.GetSortAs(&keys[i])
+		}
+		resources, err = sortExtras(resources, keys, selector.GetMissingKeyPolicy())
+		if err != nil {
+			return nil, false, err
 		}
+		if ok, err := verifyMinMatch(rsp, in, src, len(resources)); !ok {
+			return nil, err == nil, err
+		}
+		var dropped []resource.Required
+		if selector.MaxMatch != nil && uint64(len(resources)) > *selector.MaxMatch {
+			dropped = resources[*selector.MaxMatch:]
+			resources = resources[:*selector.MaxMatch]
+		}
+		response.Normal(rsp, selectionMessage(extraResName, selector, resources, dropped))
+	}
+	return resources, true, nil
+}
+
+// Verify a selector matched at least MinMatch resources. A shortfall is an
+// error, or a warning if the source is optional. Returns false on a shortfall.
+func verifyMinMatch(rsp *fnv1.RunFunctionResponse, in *v1beta1.Input, src *v1beta1.ResourceSource, matched int) (bool, error) {
+	minMatch := src.Selector.MinMatch
+	if minMatch == nil || uint64(matched) >= *minMatch {
+		return true, nil
+	}
+	err := errors.Errorf("expected at least %d extra resources %q, got %d", *minMatch, src.Into, matched)
+	if !src.IsResolutionPolicyOptional(in.Spec.Policy) {
+		return false, err
 	}
-	return &fnv1.Requirements{Resources: extraResources}, nil
+	response.Warning(rsp, err)
+	return false, nil
 }
 
-// Verify Min/Max and sort extra resources by field path within a single kind.
-func verifyAndSortExtras(in *v1beta1.Input, extraResources map[string][]resource.Required, //nolint:gocyclo // TODO(reedjosh): refactor
-) (map[string]any, error) {
-	cleanedExtras := make(map[string]any)
+// Describe which resources a selector selected, and which were dropped by its
+// maxMatch.
+func selectionMessage(into string, selector *v1beta1.ResourceSourceSelector, selected, dropped []resource.Required) string {
+	limit := func(v *uint64) string {
+		if v == nil {
+			return "none"
+		}
+		return strconv.FormatUint(*v, 10)
+	}
+	msg := fmt.Sprintf("Selected %d of %d matching extra resources %q (minMatch %s, maxMatch %s): %s",
+		len(selected), len(selected)+len(dropped), into, limit(selector.MinMatch), limit(selector.MaxMatch), extraNames(selected))
+	if len(dropped) > 0 {
+		msg += fmt.Sprintf("; dropped by maxMatch: %s", extraNames(dropped))
+	}
+	return msg
+}
+
+// Join the names of the extra resources for use in a message.
+func extraNames(extras []resource.Required) string {
+	if len(extras) == 0 {
+		return "none"
+	}
+	names := make([]string, len(extras))
+	for i, e := range extras {
+		names[i] = e.Resource.GetName()
+	}
+	return strings.Join(names, ", ")
+}
+
+// Write values of the verified extra resources to the status of the desired
+// composite resource.
+func writeToComposite(req *fnv1.RunFunctionRequest, rsp *fnv1.RunFunctionResponse, in *v1beta1.Input, extras map[string][]resource.Required) error {
+	var dxr *resource.Composite
 	for _, extraResource := range in.Spec.ExtraResources {
-		extraResName := extraResource.Into
-		resources, ok := extraResources[extraResName]
+		resources, ok := extras[extraResource.Into]
 		if !ok {
-			return nil, errors.Errorf("cannot find expected extra resource %q", extraResName)
+			continue
 		}
-		switch extraResource.GetType() {
-		case v1beta1.ResourceSourceTypeReference:
-			if len(resources) == 0 {
-				if in.Spec.Policy.IsResolutionPolicyOptional() {
+		for _, to := range extraResource.ToCompositeFieldPaths {
+			segments, err := fieldpath.Parse(to.ToFieldPath)
+			if err != nil {
+				return errors.Wrapf(err, "cannot parse field path %q", to.ToFieldPath)
+			}
+			if len(segments) == 0 || segments[0].Field != "status" {
+				return errors.Errorf("cannot write to field path %q, only the status of the composite resource can be written to", to.ToFieldPath)
+			}
+			values := make([]any, 0, len(resources))
+			for _, r := range resources {
+				v, err := fieldpath.Pave(r.Resource.Object).GetValue(to.GetFromFieldPath())
+				if fieldpath.IsNotFound(err) {
 					continue
 				}
-				return nil, errors.Errorf("Required extra resource %q not found", extraResName)
-			}
-			if len(resources) > 1 {
-				return nil, errors.Errorf("expected exactly one extra resource %q, got %d", extraResName, len(resources))
+				if err != nil {
+					return errors.Wrapf(err, "cannot get value from field path %q", to.GetFromFieldPath())
+				}
+				values = append(values, v)
 			}
-
-		case v1beta1.ResourceSourceTypeSelector:
-			selector := extraResource.Selector
-			if selector.MinMatch != nil && uint64(len(resources)) < *selector.MinMatch {
-				return nil, errors.Errorf("expected at least %d extra resources %q, got %d", *selector.MinMatch, extraResName, len(resources))
+			var value any = values
+			if extraResource.GetOutputShape() == v1beta1.ResourceSourceOutputShapeObject {
+				if len(values) == 0 {
+					continue
+				}
+				value = values[0]
 			}
-			if err := sortExtrasByFieldPath(resources, selector.GetSortByFieldPath()); err != nil {
-				return nil, err
+			if dxr == nil {
+				if dxr, err = request.GetDesiredCompositeResource(req); err != nil {
+					return errors.Wrap(err, "cannot get desired composite resource")
+				}
 			}
-			if selector.MaxMatch != nil && uint64(len(resources)) > *selector.MaxMatch {
-				resources = resources[:*selector.MaxMatch]
+			if err := fieldpath.Pave(dxr.Resource.Object).SetValue(to.ToFieldPath, value); err != nil {
+				return errors.Wrapf(err, "cannot set value at field path %q", to.ToFieldPath)
 			}
 		}
+	}
+	if dxr == nil {
+		return nil
+	}
+	return errors.Wrap(response.SetDesiredCompositeResource(rsp, dxr), "cannot set desired composite resource")
+}
 
+// Build the output written to the context from the verified extra resources,
+// either keyed by source or merged into a single object.
+func buildOutput(in *v1beta1.Input, extras map[string][]resource.Required) (map[string]any, error) {
+	if in.Spec.Merge != nil {
+		return mergeExtras(in, extras)
+	}
+	output := make(map[string]any, len(extras))
+	for _, extraResource := range in.Spec.ExtraResources {
+		extraResName := extraResource.Into
+		resources, ok := extras[extraResName]
+		if !ok {
+			continue
+		}
 		objects := make([]any, 0, len(resources))
 		for _, r := range resources {
-			objects = append(objects, r.Resource.Object)
+			o, err := projectExtra(&extraResource, r)
+			if err != nil {
+				return nil, errors.Wrapf(err, "cannot project extra resource %q", extraResName)
+			}
+			objects = append(objects, o)
+		}
+		out, err := shapeExtras(&extraResource, resources, objects)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot shape extra resources %q", extraResName)
 		}
-		cleanedExtras[extraResName] = objects
+		if out == nil {
+			continue
+		}
+		output[extraResName] = out
 	}
-	return cleanedExtras, nil
+	return output, nil
 }
 
-// Sort extra resources by field path within a single kind.
-func sortExtrasByFieldPath(extras []resource.Required, path string) error { //nolint:gocyclo // TODO(phisco): refactor
-	if path == "" {
-		return errors.New("cannot sort by empty field path")
+// Deep merge a field of all extra resources into a single object, in the
+// order of the sources and the order the resources of each source are sorted
+// in. Later resources take precedence.
+func mergeExtras(in *v1beta1.Input, extras map[string][]resource.Required) (map[string]any, error) {
+	path := in.Spec.Merge.GetFieldPath()
+	merged := map[string]any{}
+	for _, extraResource := range in.Spec.ExtraResources {
+		for _, r := range extras[extraResource.Into] {
+			v, err := fieldpath.Pave(r.Resource.DeepCopy().Object).GetValue(path)
+			if fieldpath.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, errors.Wrapf(err, "cannot get value from field path %q", path)
+			}
+			m, ok := v.(map[string]any)
+			if !ok {
+				return nil, errors.Errorf("cannot merge field path %q of extra resource %q: expected an object, got %T", path, r.Resource.GetName(), v)
+			}
+			merged = mergeDeep(merged, m)
+		}
 	}
-	p := make([]struct {
-		ec  resource.Required
-		val any
-	}, len(extras))
+	return merged, nil
+}
 
-	var t reflect.Type
-	for i := range extras {
-		p[i].ec = extras[i]
-		val, err := fieldpath.Pave(extras[i].Resource.Object).GetValue(path)
-		if err != nil && !fieldpath.IsNotFound(err) {
-			return err
+// Shape the projected objects of a source into the form in which they are put
+// into the context. Returns nil if there is nothing to put into the context.
+func shapeExtras(src *v1beta1.ResourceSource, resources []resource.Required, objects []any) (any, error) {
+	switch shape := src.GetOutputShape(); shape {
+	case v1beta1.ResourceSourceOutputShapeList:
+		return objects, nil
+	case v1beta1.ResourceSourceOutputShapeObject:
+		switch len(objects) {
+		case 0:
+			return nil, nil
+		case 1:
+			return objects[0], nil
+		default:
+			return nil, errors.Errorf("expected at most one extra resource for output shape %q, got %d", shape, len(objects))
+		}
+	case v1beta1.ResourceSourceOutputShapeMap:
+		path := src.GetOutputKeyFieldPath()
+		m := make(map[string]any, len(objects))
+		for i, r := range resources {
+			key, err := fieldpath.Pave(r.Resource.Object).GetString(path)
+			if err != nil {
+				return nil, errors.Wrapf(err, "cannot get map key from field path %q", path)
+			}
+			if _, ok := m[key]; ok {
+				return nil, errors.Errorf("duplicate map key %q at field path %q", key, path)
+			}
+			m[key] = objects[i]
 		}
-		p[i].val = val
-		if val == nil {
+		return m, nil
+	default:
+		return nil, errors.Errorf("unsupported output shape %q", shape)
+	}
+}
+
+// Project an extra resource down to the fields of it that should be put into
+// the context.
+func projectExtra(src *v1beta1.ResourceSource, r resource.Required) (map[string]any, error) {
+	obj := r.Resource.DeepCopy().Object
+	if src.GetStripManagedFields() {
+		unstructured.RemoveNestedField(obj, "metadata", "managedFields")
+	}
+	if len(src.Fields) == 0 {
+		return obj, nil
+	}
+	from := fieldpath.Pave(obj)
+	to := fieldpath.Pave(map[string]any{})
+	for _, path := range src.Fields {
+		v, err := from.GetValue(path)
+		if fieldpath.IsNotFound(err) {
 			continue
 		}
-		vt := reflect.TypeOf(val)
-		switch {
-		case t == nil:
-			t = vt
-		case t != vt:
-			return errors.Errorf("cannot sort values of different types %q and %q", t, vt)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot get value from field path %q", path)
+		}
+		if err := to.SetValue(path, v); err != nil {
+			return nil, errors.Wrapf(err, "cannot set value at field path %q", path)
 		}
 	}
-	if t == nil {
-		// we either have no values or all values are nil, we can just return
-		return nil
+	return to.UnstructuredContent(), nil
+}
+
+// Get the value of a label matcher, drawing it from the composite resource or
+// the resolved resources of another source where requested. Returns false if
+// the value is drawn from an optional field path that is not set.
+func getLabelValue(selector *v1beta1.ResourceSourceSelectorLabelMatcher, xr *resource.Composite, verified map[string][]resource.Required) (string, bool, error) {
+	switch selector.GetType() {
+	case v1beta1.ResourceSourceSelectorLabelMatcherTypeValue:
+		if selector.Value == nil {
+			return "", false, errors.New("Value cannot be nil for type 'Value'")
+		}
+		return *selector.Value, true, nil
+	case v1beta1.ResourceSourceSelectorLabelMatcherTypeFromCompositeFieldPath:
+		if selector.ValueFromFieldPath == nil {
+			return "", false, errors.New("ValueFromFieldPath cannot be nil for type 'FromCompositeFieldPath'")
+		}
+		value, err := getLabelValueFromFieldPath(xr.Resource.Object, *selector.ValueFromFieldPath, selector.FloatFormat)
+		if err != nil {
+			if fieldpath.IsNotFound(err) && selector.FromFieldPathIsOptional() {
+				return "", false, nil
+			}
+			return "", false, errors.Wrapf(err, "cannot get value of label %q from field path %q", selector.Key, *selector.ValueFromFieldPath)
+		}
+		return value, true, nil
+	case v1beta1.ResourceSourceSelectorLabelMatcherTypeFromExtraResourceFieldPath:
+		if selector.ValueFromFieldPath == nil {
+			return "", false, errors.New("ValueFromFieldPath cannot be nil for type 'FromExtraResourceFieldPath'")
+		}
+		obj, ok := getExtraResourceObject(selector.FromExtraResource, verified)
+		if !ok {
+			if !selector.FromFieldPathIsOptional() {
+				return "", false, errors.Errorf("cannot get value from extra resource %q: it resolved to no resources", selector.FromExtraResource)
+			}
+			return "", false, nil
+		}
+		value, err := getLabelValueFromFieldPath(obj, *selector.ValueFromFieldPath, selector.FloatFormat)
+		if err != nil {
+			if fieldpath.IsNotFound(err) && selector.FromFieldPathIsOptional() {
+				return "", false, nil
+			}
+			return "", false, errors.Wrapf(err, "cannot get value of label %q from field path %q of extra resource %q", selector.Key, *selector.ValueFromFieldPath, selector.FromExtraResource)
+		}
+		return value, true, nil
+	default:
+		return "", false, nil
 	}
+}
 
-	var err error
-	sort.Slice(p, func(i, j int) bool {
-		vali, valj := p[i].val, p[j].val
-		if vali == nil {
-			vali = reflect.Zero(t).Interface()
+// Get the value at a field path in its canonical string form, so that numbers
+// and booleans can be used as label values. Floats are formatted using the
+// given fmt format, or their shortest representation if not set. Unset and
+// null fields are reported as not found.
+func getLabelValueFromFieldPath(obj map[string]any, path string, floatFormat *string) (string, error) {
+	v, err := fieldpath.Pave(obj).GetValue(path)
+	if err != nil {
+		return "", err
+	}
+	switch value := v.(type) {
+	case string:
+		return value, nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case int64:
+		return strconv.FormatInt(value, 10), nil
+	case float64:
+		if floatFormat != nil {
+			return fmt.Sprintf(*floatFormat, value), nil
 		}
-		if valj == nil {
-			valj = reflect.Zero(t).Interface()
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case nil:
+		return "", nullFieldError{path: path}
+	case map[string]any:
+		return "", errors.New("cannot use an object as label value")
+	case []any:
+		return "", errors.New("cannot use an array as label value")
+	default:
+		return "", errors.Errorf("cannot use a value of type %T as label value", v)
+	}
+}
+
+// A nullFieldError reports a field that is set to null. It is treated like a
+// field that is not set by fieldpath.IsNotFound.
+type nullFieldError struct {
+	path string
+}
+
+func (e nullFieldError) Error() string {
+	return fmt.Sprintf("%s: field is null", e.path)
+}
+
+func (e nullFieldError) IsNotFound() bool {
+	return true
+}
+
+// Apply the transforms of a label matcher to its value, in order.
+func transformLabelValue(value string, transforms []v1beta1.LabelValueTransform) (string, error) { //nolint:gocyclo // exhaustive transform handling
+	for i, t := range transforms {
+		switch t.Type {
+		case v1beta1.LabelValueTransformTypeLowercase:
+			value = strings.ToLower(value)
+		case v1beta1.LabelValueTransformTypeUppercase:
+			value = strings.ToUpper(value)
+		case v1beta1.LabelValueTransformTypeMap:
+			v, ok := t.Map[value]
+			if !ok {
+				return "", errors.Errorf("transform %d: value %q not found in map", i, value)
+			}
+			value = v
+		case v1beta1.LabelValueTransformTypeReplace:
+			if t.Replace == nil {
+				return "", errors.Errorf("transform %d: Replace cannot be nil for type 'Replace'", i)
+			}
+			re, err := regexp.Compile(t.Replace.Pattern)
+			if err != nil {
+				return "", errors.Wrapf(err, "transform %d: cannot compile pattern %q", i, t.Replace.Pattern)
+			}
+			value = re.ReplaceAllString(value, t.Replace.Replacement)
+		case v1beta1.LabelValueTransformTypePrefix:
+			value = t.Value + value
+		case v1beta1.LabelValueTransformTypeSuffix:
+			value += t.Value
+		case v1beta1.LabelValueTransformTypeTruncate:
+			n := t.GetLength()
+			if len(value) <= n {
+				continue
+			}
+			// Keep truncated values unique by appending a hash of the
+			// original value.
+			if n < truncateHashLength+2 {
+				return "", errors.Errorf("transform %d: cannot truncate to %d characters, need at least %d to append a hash", i, n, truncateHashLength+2)
+			}
+			sum := sha256.Sum256([]byte(value))
+			value = value[:n-truncateHashLength-1] + "-" + hex.EncodeToString(sum[:])[:truncateHashLength]
+		default:
+			return "", errors.Errorf("transform %d: unsupported type %q", i, t.Type)
 		}
-		less, lessErr := lessByKind(t.Kind(), vali, valj)
-		if lessErr != nil {
-			err = lessErr
-			return false
+	}
+	return value, nil
+}
+
+// truncateHashLength is the number of hex characters of the hash appended to
+// truncated label values.
+const truncateHashLength = 8
+
+// labelSelectorOperators maps the operators of label expressions to their
+// label selector equivalent.
+var labelSelectorOperators = map[v1beta1.ResourceSourceSelectorLabelExpressionOperator]selection.Operator{
+	v1beta1.ResourceSourceSelectorLabelExpressionOperatorIn:           selection.In,
+	v1beta1.ResourceSourceSelectorLabelExpressionOperatorNotIn:        selection.NotIn,
+	v1beta1.ResourceSourceSelectorLabelExpressionOperatorExists:       selection.Exists,
+	v1beta1.ResourceSourceSelectorLabelExpressionOperatorDoesNotExist: selection.DoesNotExist,
+}
+
+// Build a label selector from the match expressions of a selector, drawing
+// values from the composite resource where requested.
+func buildLabelSelector(s *v1beta1.ResourceSourceSelector, xr *resource.Composite) (labels.Selector, error) {
+	selector := labels.NewSelector()
+	for _, expr := range s.MatchExpressions {
+		op, ok := labelSelectorOperators[expr.Operator]
+		if !ok {
+			return nil, errors.Errorf("unsupported operator %q for label expression %q", expr.Operator, expr.Key)
 		}
-		return less
+		values := append([]string{}, expr.Values...)
+		if expr.ValuesFromFieldPath != nil {
+			fromXR, err := getStringsFromFieldPath(xr.Resource.Object, *expr.ValuesFromFieldPath)
+			switch {
+			case err == nil:
+				values = append(values, fromXR...)
+			case !expr.FromFieldPathIsOptional():
+				return nil, errors.Wrapf(err, "cannot get values from field path %q", *expr.ValuesFromFieldPath)
+			case len(values) == 0:
+				// Nothing left to compare the label against.
+				continue
+			}
+		}
+		r, err := labels.NewRequirement(expr.Key, op, values)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid label expression %q", expr.Key)
+		}
+		selector = selector.Add(*r)
+	}
+	return selector, nil
+}
+
+// Get a list of strings from a field path that either holds a string or a
+// list of strings.
+func getStringsFromFieldPath(obj map[string]any, path string) ([]string, error) {
+	p := fieldpath.Pave(obj)
+	if v, err := p.GetString(path); err == nil {
+		return []string{v}, nil
+	}
+	return p.GetStringArray(path)
+}
+
+// Filter extra resources down to those whose labels match the selector.
+func filterExtrasByLabels(extras []resource.Required, selector labels.Selector) []resource.Required {
+	if selector.Empty() {
+		return extras
+	}
+	filtered := make([]resource.Required, 0, len(extras))
+	for _, r := range extras {
+		if selector.Matches(labels.Set(r.Resource.GetLabels())) {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered
+}
+
+// Stable sort extra resources within a single kind by the given keys, later
+// keys breaking ties of earlier ones. Values are parsed as specified by each
+// key's SortAs first. Resources missing a key are placed, dropped or rejected
+// as specified by the missing key policy.
+func sortExtras(extras []resource.Required, keys []v1beta1.ResourceSourceSelectorSortKey, missing v1beta1.MissingKeyPolicy) ([]resource.Required, error) { //nolint:gocyclo // TODO(phisco): refactor
+	type sortable struct {
+		ec   resource.Required
+		vals []any
+	}
+	types := make([]reflect.Type, len(keys))
+	p := make([]sortable, 0, len(extras))
+
+	for _, key := range keys {
+		if key.FieldPath == "" {
+			return nil, errors.New("cannot sort by empty field path")
+		}
+	}
+	for i := range extras {
+		e := sortable{ec: extras[i], vals: make([]any, len(keys))}
+		complete := true
+		for k, key := range keys {
+			val, err := fieldpath.Pave(extras[i].Resource.Object).GetValue(key.FieldPath)
+			if err != nil && !fieldpath.IsNotFound(err) {
+				return nil, err
+			}
+			if val == nil {
+				complete = false
+				continue
+			}
+			val, err = parseSortValue(key.SortAs, val)
+			if err != nil {
+				return nil, errors.Wrapf(err, "cannot sort by %q", key.FieldPath)
+			}
+			e.vals[k] = val
+			vt := reflect.TypeOf(val)
+			switch {
+			case types[k] == nil:
+				types[k] = vt
+			case types[k] != vt:
+				return nil, errors.Errorf("cannot sort by %q values of different types %q and %q", key.FieldPath, types[k], vt)
+			}
+		}
+		if !complete {
+			switch missing { //nolint:exhaustive // First and Last are handled when sorting.
+			case v1beta1.MissingKeyPolicyExclude:
+				continue
+			case v1beta1.MissingKeyPolicyError:
+				return nil, errors.Errorf("extra resource %q is missing a value to sort by", extras[i].Resource.GetName())
+			}
+		}
+		p = append(p, e)
+	}
+
+	var err error
+	sort.SliceStable(p, func(i, j int) bool {
+		for k, key := range keys {
+			vali, valj := p[i].vals[k], p[j].vals[k]
+			switch {
+			case vali == nil && valj == nil:
+				continue
+			case vali == nil:
+				return missing != v1beta1.MissingKeyPolicyLast
+			case valj == nil:
+				return missing == v1beta1.MissingKeyPolicyLast
+			}
+			if key.GetDirection() == v1beta1.SortDirectionDescending {
+				vali, valj = valj, vali
+			}
+			kind := types[k].Kind()
+			less, lessErr := lessSortValues(kind, vali, valj)
+			if lessErr != nil {
+				err = lessErr
+				return false
+			}
+			if less {
+				return true
+			}
+			// Only move on to the next key if the values are equal.
+			greater, lessErr := lessSortValues(kind, valj, vali)
+			if lessErr != nil {
+				err = lessErr
+				return false
+			}
+			if greater {
+				return false
+			}
+		}
+		return false
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for i := range extras {
-		extras[i] = p[i].ec
+	sorted := make([]resource.Required, len(p))
+	for i := range p {
+		sorted[i] = p[i].ec
+	}
+	return sorted, nil
+}
+
+// A semantic version in its canonical form with a leading "v", so that values
+// parsed as SemVer can be told apart from plain strings.
+type semVer string
+
+// Parse a value to sort by as specified. Values are returned unchanged if no
+// SortAs was specified.
+func parseSortValue(as v1beta1.SortAs, v any) (any, error) { //nolint:gocyclo // exhaustive SortAs handling
+	switch as {
+	case "":
+		return v, nil
+	case v1beta1.SortAsString:
+		return fmt.Sprint(v), nil
+	case v1beta1.SortAsNumber:
+		switch n := v.(type) {
+		case float64:
+			return n, nil
+		case int64:
+			return float64(n), nil
+		case int:
+			return float64(n), nil
+		case string:
+			f, err := strconv.ParseFloat(n, 64)
+			return f, errors.Wrapf(err, "cannot parse %q as a number", n)
+		}
+	case v1beta1.SortAsBoolean:
+		switch b := v.(type) {
+		case bool:
+			return b, nil
+		case string:
+			pb, err := strconv.ParseBool(b)
+			return pb, errors.Wrapf(err, "cannot parse %q as a boolean", b)
+		}
+	case v1beta1.SortAsTimestamp:
+		if s, ok := v.(string); ok {
+			t, err := time.Parse(time.RFC3339, s)
+			return t, errors.Wrapf(err, "cannot parse %q as a timestamp", s)
+		}
+	case v1beta1.SortAsSemVer:
+		if s, ok := v.(string); ok {
+			sv := s
+			if !strings.HasPrefix(sv, "v") {
+				sv = "v" + sv
+			}
+			if !semver.IsValid(sv) {
+				return nil, errors.Errorf("cannot parse %q as a semantic version", s)
+			}
+			return semVer(semver.Canonical(sv)), nil
+		}
+	case v1beta1.SortAsQuantity:
+		s, ok := v.(string)
+		switch n := v.(type) {
+		case float64:
+			s, ok = strconv.FormatFloat(n, 'f', -1, 64), true
+		case int64:
+			s, ok = strconv.FormatInt(n, 10), true
+		}
+		if ok {
+			q, err := k8sresource.ParseQuantity(s)
+			return q, errors.Wrapf(err, "cannot parse %q as a quantity", s)
+		}
+	default:
+		return nil, errors.Errorf("unsupported sortAs %q", as)
+	}
+	return nil, errors.Errorf("cannot sort %T as %s", v, as)
+}
+
+// Compare values parsed by parseSortValue, falling back to their kind for
+// anything but timestamps, semantic versions and quantities.
+func lessSortValues(k reflect.Kind, a, b any) (bool, error) {
+	switch va := a.(type) {
+	case time.Time:
+		vb, ok := b.(time.Time)
+		if !ok {
+			return false, errors.Errorf("cannot convert %T to %T", b, va)
+		}
+		return va.Before(vb), nil
+	case semVer:
+		vb, ok := b.(semVer)
+		if !ok {
+			return false, errors.Errorf("cannot convert %T to %T", b, va)
+		}
+		return semver.Compare(string(va), string(vb)) < 0, nil
+	case k8sresource.Quantity:
+		vb, ok := b.(k8sresource.Quantity)
+		if !ok {
+			return false, errors.Errorf("cannot convert %T to %T", b, va)
+		}
+		return va.Cmp(vb) < 0, nil
+	default:
+		return lessByKind(k, a, b)
 	}
-	return nil
 }
 
 func lessAs[T cmp.Ordered](a, b any) (bool, error) {
@@ -280,6 +1410,16 @@ func lessByKind(k reflect.Kind, a, b any) (bool, error) { //nolint:gocyclo // ex
 		return lessAs[int](a, b)
 	case reflect.String:
 		return lessAs[string](a, b)
+	case reflect.Bool:
+		va, ok := a.(bool)
+		if !ok {
+			return false, errors.Errorf("cannot convert %T to %T", a, va)
+		}
+		vb, ok := b.(bool)
+		if !ok {
+			return false, errors.Errorf("cannot convert %T to %T", b, vb)
+		}
+		return !va && vb, nil
 	default:
 		return false, errors.Errorf("unsupported type %q for sorting", k)
 	}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"strings"
 	"testing"
 
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
 	"github.com/crossplane/crossplane-runtime/v2/pkg/fieldpath"
 	"github.com/crossplane/crossplane-runtime/v2/pkg/logging"
 	"github.com/google/go-cmp/cmp"
@@ -157,8 +159,13 @@ func TestRunFunction(t *testing.T) {
 			},
 			want: want{
 				rsp: &fnv1.RunFunctionResponse{
-					Meta:    &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
-					Results: []*fnv1.Result{},
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
 					Requirements: &fnv1.Requirements{
 						Resources: map[string]*fnv1.ResourceSelector{
 							"obj-0": {
@@ -415,8 +422,29 @@ func TestRunFunction(t *testing.T) {
 			},
 			want: want{
 				rsp: &fnv1.RunFunctionResponse{
-					Meta:    &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
-					Results: []*fnv1.Result{},
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
 					Requirements: &fnv1.Requirements{
 						Resources: map[string]*fnv1.ResourceSelector{
 							"obj-0": {
@@ -536,6 +564,14 @@ func TestRunFunction(t *testing.T) {
 							}`)),
 						},
 					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_TRUE,
+							Reason: "Resolved",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
 				},
 			},
 		},
@@ -583,6 +619,10 @@ func TestRunFunction(t *testing.T) {
 				rsp: &fnv1.RunFunctionResponse{
 					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
 					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_WARNING,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
 						{
 							Severity: fnv1.Severity_SEVERITY_FATAL,
 							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
@@ -599,6 +639,14 @@ func TestRunFunction(t *testing.T) {
 							},
 						},
 					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_FALSE,
+							Reason: "ResolutionFailed",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
 				},
 			},
 		},
@@ -657,8 +705,13 @@ func TestRunFunction(t *testing.T) {
 			},
 			want: want{
 				rsp: &fnv1.RunFunctionResponse{
-					Meta:    &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
-					Results: []*fnv1.Result{},
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
 					Requirements: &fnv1.Requirements{
 						Resources: map[string]*fnv1.ResourceSelector{
 							"obj-0": {
@@ -685,204 +738,4618 @@ func TestRunFunction(t *testing.T) {
 							}`)),
 						},
 					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_TRUE,
+							Reason: "Resolved",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
 				},
 			},
 		},
-	}
-
-	for name, tc := range cases {
-		t.Run(name, func(t *testing.T) {
-			f := &Function{log: logging.NewNopLogger()}
-			rsp, err := f.RunFunction(tc.args.ctx, tc.args.req)
-
-			diff := cmp.Diff(tc.want.rsp, rsp, cmpopts.AcyclicTransformer("toJsonWithoutResultMessages", func(r *fnv1.RunFunctionResponse) []byte {
-				// We don't care about messages.
-				// cmptopts.IgnoreField wasn't working with protocmp.Transform
-				// We can't split this to another transformer as
-				// transformers are applied not in order but as soon as they
-				// match the type, which are walked from the root (RunFunctionResponse).
-				for _, result := range r.GetResults() {
-					result.Message = ""
-				}
-				out, err := protojson.Marshal(r)
-				if err != nil {
-					t.Fatalf("cannot marshal %T to JSON: %s", r, err)
-				}
-				return out
-			}))
-			if diff != "" {
-				t.Errorf("%s\nf.RunFunction(...): -want rsp, +got rsp:\n%s", tc.reason, diff)
-			}
-
-			if diff := cmp.Diff(tc.want.err, err, cmpopts.EquateErrors()); diff != "" {
-				t.Errorf("%s\nf.RunFunction(...): -want err, +got err:\n%s", tc.reason, diff)
-			}
-		})
-	}
-}
-
-func resourceWithFieldPathValue(path string, value any) resource.Required {
-	u := unstructured.Unstructured{
-		Object: map[string]any{},
-	}
-	err := fieldpath.Pave(u.Object).SetValue(path, value)
-	if err != nil {
-		panic(err)
-	}
-	return resource.Required{
-		Resource: &u,
-	}
-}
-
-func TestSortExtrasByFieldPath(t *testing.T) {
-	type args struct {
-		extras []resource.Required
-		path   string
-	}
-	type want struct {
-		extras []resource.Required
-		err    error
-	}
-
-	cases := map[string]struct {
-		reason string
-		args   args
-		want   want
-	}{
-		"SortByString": {
-			reason: "The Function should sort the Extras by the string value at the specified field path",
+		"SelectorMatchExpressions": {
+			reason: "The Function should request the widest label equality set it can and filter the returned resources by the match expressions.",
 			args: args{
-				extras: []resource.Required{
-					resourceWithFieldPathValue("metadata.name", "c"),
-					resourceWithFieldPathValue("metadata.name", "a"),
-					resourceWithFieldPathValue("metadata.name", "b"),
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								},
+								"spec": {
+									"tiers": ["prod", "staging"]
+								}
+							}`),
+						},
+					},
+					RequiredResources: map[string]*fnv1.Resources{
+						"obj-0": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "env-dev",
+										"labels": {"region": "eu", "tier": "dev", "team": "a"}
+									}
+								}`),
+								},
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "env-staging",
+										"labels": {"region": "eu", "tier": "staging", "team": "a"}
+									}
+								}`),
+								},
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "env-prod-deprecated",
+										"labels": {"region": "eu", "tier": "prod", "team": "a", "deprecated": "true"}
+									}
+								}`),
+								},
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "env-prod",
+										"labels": {"region": "eu", "tier": "prod", "team": "a"}
+									}
+								}`),
+								},
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "env-prod-unowned",
+										"labels": {"region": "eu", "tier": "prod"}
+									}
+								}`),
+								},
+							},
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"type": "Selector",
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-0",
+									"selector": {
+										"matchExpressions": [
+											{
+												"key": "region",
+												"operator": "In",
+												"values": ["eu"]
+											},
+											{
+												"key": "tier",
+												"operator": "In",
+												"valuesFromFieldPath": "spec.tiers"
+											},
+											{
+												"key": "team",
+												"operator": "Exists"
+											},
+											{
+												"key": "deprecated",
+												"operator": "DoesNotExist"
+											}
+										]
+									}
+								}
+							]
+						}
+					}`),
 				},
-				path: "metadata.name",
 			},
 			want: want{
-				extras: []resource.Required{
-					resourceWithFieldPathValue("metadata.name", "a"),
-					resourceWithFieldPathValue("metadata.name", "b"),
-					resourceWithFieldPathValue("metadata.name", "c"),
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"obj-0": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchLabels{
+									MatchLabels: &fnv1.MatchLabels{
+										Labels: map[string]string{
+											"region": "eu",
+										},
+									},
+								},
+							},
+						},
+					},
+					Context: &structpb.Struct{
+						Fields: map[string]*structpb.Value{
+							v1beta1.FunctionContextKeyExtraResources: structpb.NewStructValue(resource.MustStructJSON(`{
+								"obj-0": [
+									{
+										"apiVersion": "apiextensions.crossplane.io/v1beta1",
+										"kind": "EnvironmentConfig",
+										"metadata": {
+											"name": "env-prod",
+											"labels": {"region": "eu", "tier": "prod", "team": "a"}
+										}
+									},
+									{
+										"apiVersion": "apiextensions.crossplane.io/v1beta1",
+										"kind": "EnvironmentConfig",
+										"metadata": {
+											"name": "env-staging",
+											"labels": {"region": "eu", "tier": "staging", "team": "a"}
+										}
+									}
+								]
+							}`)),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_TRUE,
+							Reason: "Resolved",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
 				},
 			},
 		},
-		"SortByInt": {
-			reason: "The Function should sort the Extras by the int value at the specified field path",
+		"ReferenceNameFromCompositeFieldPath": {
+			reason: "The Function should request references by a name drawn from the composite resource and skip optional ones that cannot be resolved.",
 			args: args{
-				extras: []resource.Required{
-					resourceWithFieldPathValue("data.someInt", 3),
-					resourceWithFieldPathValue("data.someInt", 1),
-					resourceWithFieldPathValue("data.someInt", 2),
-				},
-				path: "data.someInt",
-			},
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								},
+								"spec": {
+									"providerConfigName": "tenant-a"
+								}
+							}`),
+						},
+					},
+					RequiredResources: map[string]*fnv1.Resources{
+						"obj-0": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "test.crossplane.io/v1alpha1",
+									"kind": "ProviderConfig",
+									"metadata": {
+										"name": "tenant-a"
+									}
+								}`),
+								},
+							},
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"kind": "ProviderConfig",
+									"apiVersion": "test.crossplane.io/v1alpha1",
+									"into": "obj-0",
+									"ref": {
+										"type": "FromCompositeFieldPath",
+										"nameFromFieldPath": "spec.providerConfigName"
+									}
+								},
+								{
+									"kind": "ProviderConfig",
+									"apiVersion": "test.crossplane.io/v1alpha1",
+									"into": "obj-1",
+									"ref": {
+										"type": "FromCompositeFieldPath",
+										"nameFromFieldPath": "spec.missingProviderConfigName",
+										"fromFieldPathPolicy": "Optional"
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"obj-0": {
+								ApiVersion: "test.crossplane.io/v1alpha1",
+								Kind:       "ProviderConfig",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "tenant-a",
+								},
+							},
+						},
+					},
+					Context: &structpb.Struct{
+						Fields: map[string]*structpb.Value{
+							v1beta1.FunctionContextKeyExtraResources: structpb.NewStructValue(resource.MustStructJSON(`{
+								"obj-0": [
+									{
+										"apiVersion": "test.crossplane.io/v1alpha1",
+										"kind": "ProviderConfig",
+										"metadata": {
+											"name": "tenant-a"
+										}
+									}
+								]
+							}`)),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_TRUE,
+							Reason: "Resolved",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"ReferenceNameFromCompositeFieldPathRequired": {
+			reason: "The Function should return fatal if a required reference name cannot be found in the composite resource.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								}
+							}`),
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"kind": "ProviderConfig",
+									"apiVersion": "test.crossplane.io/v1alpha1",
+									"into": "obj-0",
+									"ref": {
+										"type": "FromCompositeFieldPath",
+										"nameFromFieldPath": "spec.providerConfigName"
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_WARNING,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_FALSE,
+							Reason: "ResolutionFailed",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"NamespaceFromComposite": {
+			reason: "The Function should look for extra resources in the namespace of the composite resource or one drawn from its fields.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr",
+									"namespace": "my-namespace"
+								},
+								"spec": {
+									"sharedNamespace": "shared"
+								}
+							}`),
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"kind": "Foo",
+									"apiVersion": "test.crossplane.io/v1alpha1",
+									"into": "obj-0",
+									"namespaceFrom": {
+										"type": "XRNamespace"
+									},
+									"ref": {
+										"name": "my-foo"
+									}
+								},
+								{
+									"type": "Selector",
+									"kind": "Bar",
+									"apiVersion": "test.crossplane.io/v1alpha1",
+									"into": "obj-1",
+									"namespaceFrom": {
+										"type": "FromCompositeFieldPath",
+										"valueFromFieldPath": "spec.sharedNamespace"
+									},
+									"selector": {
+										"matchLabels": [
+											{
+												"type": "Value",
+												"key": "foo",
+												"value": "bar"
+											}
+										]
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta:    &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"obj-0": {
+								ApiVersion: "test.crossplane.io/v1alpha1",
+								Kind:       "Foo",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "my-foo",
+								},
+								Namespace: ptr.To("my-namespace"),
+							},
+							"obj-1": {
+								ApiVersion: "test.crossplane.io/v1alpha1",
+								Kind:       "Bar",
+								Match: &fnv1.ResourceSelector_MatchLabels{
+									MatchLabels: &fnv1.MatchLabels{
+										Labels: map[string]string{
+											"foo": "bar",
+										},
+									},
+								},
+								Namespace: ptr.To("shared"),
+							},
+						},
+					},
+				},
+			},
+		},
+		"NamespaceFromClusterScopedComposite": {
+			reason: "The Function should return fatal if the namespace of a cluster scoped composite resource is requested.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								}
+							}`),
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"kind": "Foo",
+									"apiVersion": "test.crossplane.io/v1alpha1",
+									"into": "obj-0",
+									"namespaceFrom": {
+										"type": "XRNamespace"
+									},
+									"ref": {
+										"name": "my-foo"
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_WARNING,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_FALSE,
+							Reason: "ResolutionFailed",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"PerSourcePolicy": {
+			reason: "The Function should honour the resolution policy of each source, turning an optional selector's MinMatch shortfall into an empty list and a warning.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								}
+							}`),
+						},
+					},
+					RequiredResources: map[string]*fnv1.Resources{
+						"obj-0": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "my-env-config"
+									}
+								}`),
+								},
+							},
+						},
+						"obj-1": {
+							Items: []*fnv1.Resource{},
+						},
+						"obj-2": {
+							Items: []*fnv1.Resource{},
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"policy": {
+								"resolution": "Required"
+							},
+							"extraResources": [
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-0",
+									"ref": {
+										"name": "my-env-config"
+									}
+								},
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-1",
+									"ref": {
+										"name": "my-optional-env-config"
+									},
+									"policy": {
+										"resolution": "Optional"
+									}
+								},
+								{
+									"type": "Selector",
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-2",
+									"selector": {
+										"minMatch": 1,
+										"matchLabels": [
+											{
+												"type": "Value",
+												"key": "foo",
+												"value": "bar"
+											}
+										]
+									},
+									"policy": {
+										"resolution": "Optional"
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_WARNING,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_WARNING,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"obj-0": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "my-env-config",
+								},
+							},
+							"obj-1": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "my-optional-env-config",
+								},
+							},
+							"obj-2": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchLabels{
+									MatchLabels: &fnv1.MatchLabels{
+										Labels: map[string]string{
+											"foo": "bar",
+										},
+									},
+								},
+							},
+						},
+					},
+					Context: &structpb.Struct{
+						Fields: map[string]*structpb.Value{
+							v1beta1.FunctionContextKeyExtraResources: structpb.NewStructValue(resource.MustStructJSON(`{
+								"obj-0": [
+									{
+										"apiVersion": "apiextensions.crossplane.io/v1beta1",
+										"kind": "EnvironmentConfig",
+										"metadata": {
+											"name": "my-env-config"
+										}
+									}
+								],
+								"obj-2": []
+							}`)),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_FALSE,
+							Reason: "OptionalResourcesUnresolved",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"PerSourcePolicyRequired": {
+			reason: "The Function should return fatal if a source requires its resource even though the spec level policy is optional.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								}
+							}`),
+						},
+					},
+					RequiredResources: map[string]*fnv1.Resources{
+						"obj-0": {
+							Items: []*fnv1.Resource{},
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"policy": {
+								"resolution": "Optional"
+							},
+							"extraResources": [
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-0",
+									"ref": {
+										"name": "my-env-config"
+									},
+									"policy": {
+										"resolution": "Required"
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_WARNING,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"obj-0": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "my-env-config",
+								},
+							},
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_FALSE,
+							Reason: "ResolutionFailed",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"ProjectFields": {
+			reason: "The Function should strip managed fields by default and only put the selected fields into the context when asked to.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								}
+							}`),
+						},
+					},
+					RequiredResources: map[string]*fnv1.Resources{
+						"obj-0": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "my-env-config",
+										"annotations": {"foo": "bar"},
+										"managedFields": [{"manager": "kubectl"}]
+									},
+									"data": {
+										"firstKey": "firstVal"
+									}
+								}`),
+								},
+							},
+						},
+						"obj-1": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "my-env-config",
+										"annotations": {"foo": "bar"},
+										"managedFields": [{"manager": "kubectl"}]
+									},
+									"data": {
+										"firstKey": "firstVal",
+										"secondKey": "secondVal"
+									}
+								}`),
+								},
+							},
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-0",
+									"ref": {
+										"name": "my-env-config"
+									}
+								},
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-1",
+									"ref": {
+										"name": "my-env-config"
+									},
+									"fields": [
+										"metadata.name",
+										"data.secondKey",
+										"status.missing"
+									]
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"obj-0": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "my-env-config",
+								},
+							},
+							"obj-1": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "my-env-config",
+								},
+							},
+						},
+					},
+					Context: &structpb.Struct{
+						Fields: map[string]*structpb.Value{
+							v1beta1.FunctionContextKeyExtraResources: structpb.NewStructValue(resource.MustStructJSON(`{
+								"obj-0": [
+									{
+										"apiVersion": "apiextensions.crossplane.io/v1beta1",
+										"kind": "EnvironmentConfig",
+										"metadata": {
+											"name": "my-env-config",
+											"annotations": {"foo": "bar"}
+										},
+										"data": {
+											"firstKey": "firstVal"
+										}
+									}
+								],
+								"obj-1": [
+									{
+										"metadata": {
+											"name": "my-env-config"
+										},
+										"data": {
+											"secondKey": "secondVal"
+										}
+									}
+								]
+							}`)),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_TRUE,
+							Reason: "Resolved",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"OutputShapes": {
+			reason: "The Function should put extra resources into the context as a list, a single object or a map depending on the output shape.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								}
+							}`),
+						},
+					},
+					RequiredResources: map[string]*fnv1.Resources{
+						"obj-0": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "my-env-config"
+									}
+								}`),
+								},
+							},
+						},
+						"obj-1": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "env-b"
+									},
+									"data": {
+										"region": "us"
+									}
+								}`),
+								},
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "env-a"
+									},
+									"data": {
+										"region": "eu"
+									}
+								}`),
+								},
+							},
+						},
+						"obj-2": {
+							Items: []*fnv1.Resource{},
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-0",
+									"outputShape": "Object",
+									"ref": {
+										"name": "my-env-config"
+									}
+								},
+								{
+									"type": "Selector",
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-1",
+									"outputShape": "Map",
+									"outputKeyFieldPath": "data.region",
+									"fields": ["metadata.name"],
+									"selector": {
+										"matchLabels": [
+											{
+												"type": "Value",
+												"key": "foo",
+												"value": "bar"
+											}
+										]
+									}
+								},
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-2",
+									"outputShape": "Object",
+									"ref": {
+										"name": "my-missing-env-config"
+									},
+									"policy": {
+										"resolution": "Optional"
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_WARNING,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"obj-0": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "my-env-config",
+								},
+							},
+							"obj-1": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchLabels{
+									MatchLabels: &fnv1.MatchLabels{
+										Labels: map[string]string{
+											"foo": "bar",
+										},
+									},
+								},
+							},
+							"obj-2": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "my-missing-env-config",
+								},
+							},
+						},
+					},
+					Context: &structpb.Struct{
+						Fields: map[string]*structpb.Value{
+							v1beta1.FunctionContextKeyExtraResources: structpb.NewStructValue(resource.MustStructJSON(`{
+								"obj-0": {
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "my-env-config"
+									}
+								},
+								"obj-1": {
+									"eu": {
+										"metadata": {
+											"name": "env-a"
+										}
+									},
+									"us": {
+										"metadata": {
+											"name": "env-b"
+										}
+									}
+								}
+							}`)),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_FALSE,
+							Reason: "OptionalResourcesUnresolved",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"MergeDeepIntoContextKey": {
+			reason: "The Function should deep merge resolved extra resources into an existing context key when asked to.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								}
+							}`),
+						},
+					},
+					Context: resource.MustStructJSON(`{
+						"apiextensions.crossplane.io/environment": {
+							"existing": "value",
+							"obj-0": {
+								"kept": "value",
+								"metadata": {
+									"name": "overwritten"
+								}
+							}
+						}
+					}`),
+					RequiredResources: map[string]*fnv1.Resources{
+						"obj-0": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "my-env-config"
+									}
+								}`),
+								},
+							},
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"context": {
+								"key": "apiextensions.crossplane.io/environment",
+								"mode": "MergeDeep"
+							},
+							"extraResources": [
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-0",
+									"outputShape": "Object",
+									"ref": {
+										"name": "my-env-config"
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"obj-0": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "my-env-config",
+								},
+							},
+						},
+					},
+					Context: &structpb.Struct{
+						Fields: map[string]*structpb.Value{
+							"apiextensions.crossplane.io/environment": structpb.NewStructValue(resource.MustStructJSON(`{
+								"existing": "value",
+								"obj-0": {
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"kept": "value",
+									"metadata": {
+										"name": "my-env-config"
+									}
+								}
+							}`)),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_TRUE,
+							Reason: "Resolved",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"MergeShallowIntoContextKey": {
+			reason: "The Function should overwrite top level fields of an existing context key when merging shallowly.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								}
+							}`),
+						},
+					},
+					Context: resource.MustStructJSON(`{
+						"apiextensions.crossplane.io/environment": {
+							"existing": "value",
+							"obj-0": {
+								"kept": "value"
+							}
+						}
+					}`),
+					RequiredResources: map[string]*fnv1.Resources{
+						"obj-0": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "my-env-config"
+									}
+								}`),
+								},
+							},
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"context": {
+								"key": "apiextensions.crossplane.io/environment",
+								"mode": "MergeShallow"
+							},
+							"extraResources": [
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-0",
+									"outputShape": "Object",
+									"ref": {
+										"name": "my-env-config"
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"obj-0": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "my-env-config",
+								},
+							},
+						},
+					},
+					Context: &structpb.Struct{
+						Fields: map[string]*structpb.Value{
+							"apiextensions.crossplane.io/environment": structpb.NewStructValue(resource.MustStructJSON(`{
+								"existing": "value",
+								"obj-0": {
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "my-env-config"
+									}
+								}
+							}`)),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_TRUE,
+							Reason: "Resolved",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"MergeData": {
+			reason: "The Function should deep merge the data of all resolved extra resources in order into the context key when asked to.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								}
+							}`),
+						},
+					},
+					RequiredResources: map[string]*fnv1.Resources{
+						"obj-0": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "base"
+									},
+									"data": {
+										"region": "eu",
+										"network": {
+											"cidr": "10.0.0.0/16",
+											"shared": false
+										}
+									}
+								}`),
+								},
+							},
+						},
+						"obj-1": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "override-b"
+									},
+									"data": {
+										"network": {
+											"shared": true
+										}
+									}
+								}`),
+								},
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "override-a"
+									},
+									"data": {
+										"region": "us",
+										"network": {
+											"shared": false
+										}
+									}
+								}`),
+								},
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "override-c"
+									}
+								}`),
+								},
+							},
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"context": {
+								"key": "apiextensions.crossplane.io/environment"
+							},
+							"merge": {
+								"fieldPath": "data"
+							},
+							"extraResources": [
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-0",
+									"ref": {
+										"name": "base"
+									}
+								},
+								{
+									"type": "Selector",
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-1",
+									"selector": {
+										"matchLabels": [
+											{
+												"type": "Value",
+												"key": "override",
+												"value": "true"
+											}
+										]
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"obj-0": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "base",
+								},
+							},
+							"obj-1": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchLabels{
+									MatchLabels: &fnv1.MatchLabels{
+										Labels: map[string]string{
+											"override": "true",
+										},
+									},
+								},
+							},
+						},
+					},
+					Context: &structpb.Struct{
+						Fields: map[string]*structpb.Value{
+							"apiextensions.crossplane.io/environment": structpb.NewStructValue(resource.MustStructJSON(`{
+								"region": "us",
+								"network": {
+									"cidr": "10.0.0.0/16",
+									"shared": true
+								}
+							}`)),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_TRUE,
+							Reason: "Resolved",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"ToCompositeFieldPaths": {
+			reason: "The Function should write values of the resolved extra resources to the status of the desired composite resource.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								}
+							}`),
+						},
+					},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"status": {
+									"existing": "value"
+								}
+							}`),
+						},
+					},
+					RequiredResources: map[string]*fnv1.Resources{
+						"obj-0": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "my-env-config"
+									},
+									"data": {
+										"region": "eu"
+									}
+								}`),
+								},
+							},
+						},
+						"obj-1": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "env-b"
+									}
+								}`),
+								},
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "env-a"
+									}
+								}`),
+								},
+							},
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-0",
+									"outputShape": "Object",
+									"fields": ["metadata.name"],
+									"ref": {
+										"name": "my-env-config"
+									},
+									"toCompositeFieldPaths": [
+										{
+											"fromFieldPath": "data.region",
+											"toFieldPath": "status.region"
+										}
+									]
+								},
+								{
+									"type": "Selector",
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-1",
+									"fields": ["metadata.name"],
+									"selector": {
+										"matchLabels": [
+											{
+												"type": "Value",
+												"key": "foo",
+												"value": "bar"
+											}
+										]
+									},
+									"toCompositeFieldPaths": [
+										{
+											"toFieldPath": "status.environmentConfigs"
+										}
+									]
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"status": {
+									"existing": "value",
+									"region": "eu",
+									"environmentConfigs": ["env-a", "env-b"]
+								}
+							}`),
+						},
+					},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"obj-0": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "my-env-config",
+								},
+							},
+							"obj-1": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchLabels{
+									MatchLabels: &fnv1.MatchLabels{
+										Labels: map[string]string{
+											"foo": "bar",
+										},
+									},
+								},
+							},
+						},
+					},
+					Context: &structpb.Struct{
+						Fields: map[string]*structpb.Value{
+							v1beta1.FunctionContextKeyExtraResources: structpb.NewStructValue(resource.MustStructJSON(`{
+								"obj-0": {
+									"metadata": {
+										"name": "my-env-config"
+									}
+								},
+								"obj-1": [
+									{
+										"metadata": {
+											"name": "env-a"
+										}
+									},
+									{
+										"metadata": {
+											"name": "env-b"
+										}
+									}
+								]
+							}`)),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_TRUE,
+							Reason: "Resolved",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"FilterExpression": {
+			reason: "The Function should only keep the extra resources the filter expression evaluates to true for before checking minMatch.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								},
+								"spec": {
+									"region": "eu"
+								}
+							}`),
+						},
+					},
+					RequiredResources: map[string]*fnv1.Resources{
+						"obj-0": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "env-a"
+									},
+									"data": {
+										"regions": ["us", "eu"],
+										"enabled": true
+									}
+								}`),
+								},
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "env-b"
+									},
+									"data": {
+										"regions": ["us"],
+										"enabled": true
+									}
+								}`),
+								},
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "env-c"
+									},
+									"data": {
+										"regions": ["eu"],
+										"enabled": false
+									}
+								}`),
+								},
+							},
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"type": "Selector",
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-0",
+									"fields": ["metadata.name"],
+									"filter": "xr.spec.region in object.data.regions && object.data.enabled",
+									"selector": {
+										"minMatch": 1,
+										"maxMatch": 1,
+										"matchLabels": [
+											{
+												"type": "Value",
+												"key": "foo",
+												"value": "bar"
+											}
+										]
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"obj-0": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchLabels{
+									MatchLabels: &fnv1.MatchLabels{
+										Labels: map[string]string{
+											"foo": "bar",
+										},
+									},
+								},
+							},
+						},
+					},
+					Context: &structpb.Struct{
+						Fields: map[string]*structpb.Value{
+							v1beta1.FunctionContextKeyExtraResources: structpb.NewStructValue(resource.MustStructJSON(`{
+								"obj-0": [
+									{
+										"metadata": {
+											"name": "env-a"
+										}
+									}
+								]
+							}`)),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_TRUE,
+							Reason: "Resolved",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"FilterExpressionInvalid": {
+			reason: "The Function should return a fatal result if the filter expression does not evaluate to a bool, before requesting anything.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								}
+							}`),
+						},
+					},
+					RequiredResources: map[string]*fnv1.Resources{
+						"obj-0": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "my-env-config"
+									}
+								}`),
+								},
+							},
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-0",
+									"filter": "object.metadata.name + 'suffix'",
+									"ref": {
+										"name": "my-env-config"
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_FALSE,
+							Reason: "ResolutionFailed",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"ChainedLookupFirstStage": {
+			reason: "The Function should only request sources that do not depend on other sources at first.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								},
+								"spec": {
+									"environment": "production"
+								}
+							}`),
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"kind": "Cluster",
+									"apiVersion": "example.crossplane.io/v1",
+									"into": "cluster",
+									"fields": ["metadata.name"],
+									"outputShape": "Object",
+									"ref": {
+										"type": "FromExtraResourceFieldPath",
+										"fromExtraResource": "env",
+										"nameFromFieldPath": "data.cluster"
+									}
+								},
+								{
+									"type": "Selector",
+									"kind": "ConfigMap",
+									"apiVersion": "v1",
+									"into": "configs",
+									"fields": ["metadata.name"],
+									"selector": {
+										"matchLabels": [
+											{
+												"type": "FromExtraResourceFieldPath",
+												"key": "team",
+												"fromExtraResource": "env",
+												"valueFromFieldPath": "data.team"
+											}
+										]
+									}
+								},
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "env",
+									"fields": ["metadata.name"],
+									"outputShape": "Object",
+									"ref": {
+										"type": "FromCompositeFieldPath",
+										"nameFromFieldPath": "spec.environment"
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta:    &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"env": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "production",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		"ChainedLookupSecondStage": {
+			reason: "The Function should request sources drawing values from another source once that source is resolved.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								},
+								"spec": {
+									"environment": "production"
+								}
+							}`),
+						},
+					},
+					RequiredResources: map[string]*fnv1.Resources{
+						"env": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "production"
+									},
+									"data": {
+										"cluster": "cluster-a",
+										"team": "platform"
+									}
+								}`),
+								},
+							},
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"kind": "Cluster",
+									"apiVersion": "example.crossplane.io/v1",
+									"into": "cluster",
+									"fields": ["metadata.name"],
+									"outputShape": "Object",
+									"ref": {
+										"type": "FromExtraResourceFieldPath",
+										"fromExtraResource": "env",
+										"nameFromFieldPath": "data.cluster"
+									}
+								},
+								{
+									"type": "Selector",
+									"kind": "ConfigMap",
+									"apiVersion": "v1",
+									"into": "configs",
+									"fields": ["metadata.name"],
+									"selector": {
+										"matchLabels": [
+											{
+												"type": "FromExtraResourceFieldPath",
+												"key": "team",
+												"fromExtraResource": "env",
+												"valueFromFieldPath": "data.team"
+											}
+										]
+									}
+								},
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "env",
+									"fields": ["metadata.name"],
+									"outputShape": "Object",
+									"ref": {
+										"type": "FromCompositeFieldPath",
+										"nameFromFieldPath": "spec.environment"
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"cluster": {
+								ApiVersion: "example.crossplane.io/v1",
+								Kind:       "Cluster",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "cluster-a",
+								},
+							},
+							"configs": {
+								ApiVersion: "v1",
+								Kind:       "ConfigMap",
+								Match: &fnv1.ResourceSelector_MatchLabels{
+									MatchLabels: &fnv1.MatchLabels{
+										Labels: map[string]string{
+											"team": "platform",
+										},
+									},
+								},
+							},
+							"env": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "production",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		"ChainedLookupResolved": {
+			reason: "The Function should write the resources of all sources to the context once the resources of dependent sources are present.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								},
+								"spec": {
+									"environment": "production"
+								}
+							}`),
+						},
+					},
+					RequiredResources: map[string]*fnv1.Resources{
+						"env": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "production"
+									},
+									"data": {
+										"cluster": "cluster-a",
+										"team": "platform"
+									}
+								}`),
+								},
+							},
+						},
+						"cluster": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "example.crossplane.io/v1",
+									"kind": "Cluster",
+									"metadata": {
+										"name": "cluster-a"
+									}
+								}`),
+								},
+							},
+						},
+						"configs": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "v1",
+									"kind": "ConfigMap",
+									"metadata": {
+										"name": "platform-settings",
+										"labels": {
+											"team": "platform"
+										}
+									}
+								}`),
+								},
+							},
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"kind": "Cluster",
+									"apiVersion": "example.crossplane.io/v1",
+									"into": "cluster",
+									"fields": ["metadata.name"],
+									"outputShape": "Object",
+									"ref": {
+										"type": "FromExtraResourceFieldPath",
+										"fromExtraResource": "env",
+										"nameFromFieldPath": "data.cluster"
+									}
+								},
+								{
+									"type": "Selector",
+									"kind": "ConfigMap",
+									"apiVersion": "v1",
+									"into": "configs",
+									"fields": ["metadata.name"],
+									"selector": {
+										"matchLabels": [
+											{
+												"type": "FromExtraResourceFieldPath",
+												"key": "team",
+												"fromExtraResource": "env",
+												"valueFromFieldPath": "data.team"
+											}
+										]
+									}
+								},
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "env",
+									"fields": ["metadata.name"],
+									"outputShape": "Object",
+									"ref": {
+										"type": "FromCompositeFieldPath",
+										"nameFromFieldPath": "spec.environment"
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"cluster": {
+								ApiVersion: "example.crossplane.io/v1",
+								Kind:       "Cluster",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "cluster-a",
+								},
+							},
+							"configs": {
+								ApiVersion: "v1",
+								Kind:       "ConfigMap",
+								Match: &fnv1.ResourceSelector_MatchLabels{
+									MatchLabels: &fnv1.MatchLabels{
+										Labels: map[string]string{
+											"team": "platform",
+										},
+									},
+								},
+							},
+							"env": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "production",
+								},
+							},
+						},
+					},
+					Context: &structpb.Struct{
+						Fields: map[string]*structpb.Value{
+							v1beta1.FunctionContextKeyExtraResources: structpb.NewStructValue(resource.MustStructJSON(`{
+								"env": {
+									"metadata": {
+										"name": "production"
+									}
+								},
+								"cluster": {
+									"metadata": {
+										"name": "cluster-a"
+									}
+								},
+								"configs": [
+									{
+										"metadata": {
+											"name": "platform-settings"
+										}
+									}
+								]
+							}`)),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_TRUE,
+							Reason: "Resolved",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"ChainedLookupCycle": {
+			reason: "The Function should return a fatal result if sources depend on each other in a cycle.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								}
+							}`),
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "a",
+									"ref": {
+										"type": "FromExtraResourceFieldPath",
+										"fromExtraResource": "b",
+										"nameFromFieldPath": "data.next"
+									}
+								},
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "b",
+									"ref": {
+										"type": "FromExtraResourceFieldPath",
+										"fromExtraResource": "a",
+										"nameFromFieldPath": "data.next"
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_FALSE,
+							Reason: "ResolutionFailed",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"ConditionalSources": {
+			reason: "The Function should only request sources whose condition holds and leave the others out of the context.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								},
+								"spec": {
+									"networking": {
+										"mode": "dedicated"
+									},
+									"replicas": 3
+								}
+							}`),
+						},
+					},
+					RequiredResources: map[string]*fnv1.Resources{
+						"scaling": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "scaling"
+									}
+								}`),
+								},
+							},
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "vpc",
+									"ref": {
+										"name": "shared-vpc"
+									},
+									"when": {
+										"type": "FieldPathEquals",
+										"fieldPath": "spec.networking.mode",
+										"value": "shared"
+									}
+								},
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "peering",
+									"ref": {
+										"name": "peering"
+									},
+									"when": {
+										"type": "FieldPathExists",
+										"fieldPath": "spec.networking.peerWith"
+									}
+								},
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "scaling",
+									"fields": ["metadata.name"],
+									"ref": {
+										"name": "scaling"
+									},
+									"when": {
+										"type": "Expression",
+										"expression": "xr.spec.replicas > 2"
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"scaling": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "scaling",
+								},
+							},
+						},
+					},
+					Context: &structpb.Struct{
+						Fields: map[string]*structpb.Value{
+							v1beta1.FunctionContextKeyExtraResources: structpb.NewStructValue(resource.MustStructJSON(`{
+								"scaling": [
+									{
+										"metadata": {
+											"name": "scaling"
+										}
+									}
+								]
+							}`)),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_TRUE,
+							Reason: "Resolved",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"ReferenceNameTemplate": {
+			reason: "The Function should request references by the name rendered from their template, skipping optional ones referring to missing fields.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								},
+								"spec": {
+									"region": "eu-west-1"
+								}
+							}`),
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "defaults",
+									"ref": {
+										"type": "Template",
+										"nameTemplate": "{{ .spec.region }}-{{ .metadata.name }}-defaults"
+									}
+								},
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "env",
+									"ref": {
+										"type": "Template",
+										"nameTemplate": "{{ .spec.region }}-{{ .spec.env }}",
+										"fromFieldPathPolicy": "Optional"
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"defaults": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "eu-west-1-my-xr-defaults",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		"ReferenceNameTemplateRequired": {
+			reason: "The Function should return a fatal result if a required name template refers to a missing field.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								},
+								"spec": {
+									"region": "eu-west-1"
+								}
+							}`),
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "defaults",
+									"ref": {
+										"type": "Template",
+										"nameTemplate": "{{ .spec.region }}-{{ .metadata.name }}-defaults"
+									}
+								},
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "env",
+									"ref": {
+										"type": "Template",
+										"nameTemplate": "{{ .spec.region }}-{{ .spec.env }}"
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_WARNING,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_FALSE,
+							Reason: "ResolutionFailed",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"ReferenceCandidates": {
+			reason: "The Function should request all resolvable candidates of a reference at once and use the first one that exists.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								},
+								"spec": {
+									"region": "eu"
+								}
+							}`),
+						},
+					},
+					RequiredResources: map[string]*fnv1.Resources{
+						"config[1]": {
+							Items: []*fnv1.Resource{},
+						},
+						"config[2]": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "global-defaults"
+									}
+								}`),
+								},
+							},
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "config",
+									"fields": ["metadata.name"],
+									"outputShape": "Object",
+									"ref": {
+										"type": "Candidates",
+										"candidates": [
+											{
+												"type": "FromCompositeFieldPath",
+												"nameFromFieldPath": "spec.tenant"
+											},
+											{
+												"type": "Template",
+												"nameTemplate": "{{ .spec.region }}-defaults"
+											},
+											{
+												"name": "global-defaults"
+											}
+										]
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"config[1]": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "eu-defaults",
+								},
+							},
+							"config[2]": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "global-defaults",
+								},
+							},
+						},
+					},
+					Context: &structpb.Struct{
+						Fields: map[string]*structpb.Value{
+							v1beta1.FunctionContextKeyExtraResources: structpb.NewStructValue(resource.MustStructJSON(`{
+								"config": {
+									"metadata": {
+										"name": "global-defaults"
+									}
+								}
+							}`)),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_TRUE,
+							Reason: "Resolved",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"ReferenceCandidatesNoneFound": {
+			reason: "The Function should return a fatal result if none of the candidates of a required reference exist.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								}
+							}`),
+						},
+					},
+					RequiredResources: map[string]*fnv1.Resources{
+						"config[0]": {
+							Items: []*fnv1.Resource{},
+						},
+						"config[1]": {
+							Items: []*fnv1.Resource{},
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "config",
+									"ref": {
+										"type": "Candidates",
+										"candidates": [
+											{
+												"name": "tenant-defaults"
+											},
+											{
+												"name": "global-defaults"
+											}
+										]
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_WARNING,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"config[0]": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "tenant-defaults",
+								},
+							},
+							"config[1]": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "global-defaults",
+								},
+							},
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_FALSE,
+							Reason: "ResolutionFailed",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"LabelValueTransforms": {
+			reason: "The Function should transform label values drawn from the composite resource before requesting the extra resources.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								},
+								"spec": {
+									"team": "Platform/Core",
+									"tier": "Production"
+								}
+							}`),
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"type": "Selector",
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-0",
+									"selector": {
+										"matchLabels": [
+											{
+												"key": "team",
+												"valueFromFieldPath": "spec.team",
+												"transforms": [
+													{"type": "Lowercase"},
+													{"type": "Replace", "replace": {"pattern": "[^a-z0-9]+", "replacement": "-"}},
+													{"type": "Prefix", "value": "team-"}
+												]
+											},
+											{
+												"key": "tier",
+												"valueFromFieldPath": "spec.tier",
+												"transforms": [
+													{"type": "Map", "map": {"Production": "prod", "Development": "dev"}}
+												]
+											}
+										]
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta:    &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"obj-0": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchLabels{
+									MatchLabels: &fnv1.MatchLabels{
+										Labels: map[string]string{
+											"team": "team-platform-core",
+											"tier": "prod",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		"InvalidLabelValue": {
+			reason: "The Function should return a fatal result if a label value is not a valid label value.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								},
+								"spec": {
+									"team": "Platform/Core"
+								}
+							}`),
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"type": "Selector",
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-0",
+									"selector": {
+										"matchLabels": [
+											{
+												"key": "team",
+												"valueFromFieldPath": "spec.team",
+												"transforms": [
+													{"type": "Lowercase"}
+												]
+											}
+										]
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_WARNING,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_FALSE,
+							Reason: "ResolutionFailed",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"NonStringLabelValues": {
+			reason: "The Function should use numbers and booleans of the composite resource as label values in their canonical string form.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								},
+								"spec": {
+									"replicas": 3,
+									"ha": true,
+									"ratio": 0.5
+								}
+							}`),
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"type": "Selector",
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-0",
+									"selector": {
+										"matchLabels": [
+											{
+												"key": "replicas",
+												"valueFromFieldPath": "spec.replicas"
+											},
+											{
+												"key": "ha",
+												"valueFromFieldPath": "spec.ha"
+											},
+											{
+												"key": "ratio",
+												"valueFromFieldPath": "spec.ratio",
+												"floatFormat": "%.2f"
+											}
+										]
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta:    &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"obj-0": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchLabels{
+									MatchLabels: &fnv1.MatchLabels{
+										Labels: map[string]string{
+											"replicas": "3",
+											"ha":       "true",
+											"ratio":    "0.50",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		"ObjectLabelValue": {
+			reason: "The Function should return a fatal result if a label value is drawn from an object, even if the field path is optional.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								},
+								"spec": {
+									"networking": {
+										"mode": "shared"
+									}
+								}
+							}`),
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"type": "Selector",
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-0",
+									"selector": {
+										"matchLabels": [
+											{
+												"key": "networking",
+												"valueFromFieldPath": "spec.networking",
+												"fromFieldPathPolicy": "Optional"
+											}
+										]
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_WARNING,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_FALSE,
+							Reason: "ResolutionFailed",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"EmptySelectors": {
+			reason: "The Function should select all resources of a kind for matchAll and empty selectors with the MatchAll policy, and skip other empty selectors.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								}
+							}`),
+						},
+					},
+					RequiredResources: map[string]*fnv1.Resources{
+						"all": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "env-a"
+									}
+								}`),
+								},
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "env-b"
+									}
+								}`),
+								},
+							},
+						},
+						"fallback": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"kind": "EnvironmentConfig",
+									"metadata": {
+										"name": "env-a"
+									}
+								}`),
+								},
+							},
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"type": "Selector",
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "all",
+									"fields": ["metadata.name"],
+									"selector": {
+										"matchAll": true
+									}
+								},
+								{
+									"type": "Selector",
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "fallback",
+									"fields": ["metadata.name"],
+									"selector": {
+										"maxMatch": 1,
+										"onEmptySelector": "MatchAll",
+										"matchLabels": [
+											{
+												"key": "team",
+												"valueFromFieldPath": "spec.team",
+												"fromFieldPathPolicy": "Optional"
+											}
+										]
+									}
+								},
+								{
+									"type": "Selector",
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "skipped",
+									"fields": ["metadata.name"],
+									"selector": {
+										"matchLabels": [
+											{
+												"key": "team",
+												"valueFromFieldPath": "spec.team",
+												"fromFieldPathPolicy": "Optional"
+											}
+										]
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"all": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchLabels{
+									MatchLabels: &fnv1.MatchLabels{
+										Labels: map[string]string{},
+									},
+								},
+							},
+							"fallback": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchLabels{
+									MatchLabels: &fnv1.MatchLabels{
+										Labels: map[string]string{},
+									},
+								},
+							},
+						},
+					},
+					Context: &structpb.Struct{
+						Fields: map[string]*structpb.Value{
+							v1beta1.FunctionContextKeyExtraResources: structpb.NewStructValue(resource.MustStructJSON(`{
+								"all": [
+									{
+										"metadata": {
+											"name": "env-a"
+										}
+									},
+									{
+										"metadata": {
+											"name": "env-b"
+										}
+									}
+								],
+								"fallback": [
+									{
+										"metadata": {
+											"name": "env-a"
+										}
+									}
+								]
+							}`)),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_TRUE,
+							Reason: "Resolved",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"EmptySelectorError": {
+			reason: "The Function should return a fatal result for an empty selector with the Error policy.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								}
+							}`),
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"type": "Selector",
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-0",
+									"fields": ["metadata.name"],
+									"selector": {
+										"onEmptySelector": "Error",
+										"matchLabels": [
+											{
+												"key": "team",
+												"valueFromFieldPath": "spec.team",
+												"fromFieldPathPolicy": "Optional"
+											}
+										]
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_WARNING,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_FALSE,
+							Reason: "ResolutionFailed",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"AggregateBuildErrors": {
+			reason: "The Function should report the errors of all sources whose requirements cannot be built, not only the first one.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								}
+							}`),
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"kind": "ProviderConfig",
+									"apiVersion": "test.crossplane.io/v1alpha1",
+									"into": "obj-0",
+									"ref": {
+										"type": "FromCompositeFieldPath",
+										"nameFromFieldPath": "spec.providerConfigName"
+									}
+								},
+								{
+									"type": "Selector",
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-1",
+									"selector": {
+										"matchLabels": [
+											{
+												"key": "team",
+												"type": "FromCompositeFieldPath",
+												"valueFromFieldPath": "spec.team"
+											}
+										]
+									}
+								},
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-2",
+									"ref": {
+										"name": "my-env-config"
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_WARNING,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_WARNING,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_FALSE,
+							Reason: "ResolutionFailed",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"AggregateVerifyErrors": {
+			reason: "The Function should report the errors of all sources whose resources cannot be verified, not only the first one.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								}
+							}`),
+						},
+					},
+					RequiredResources: map[string]*fnv1.Resources{
+						"obj-0": {
+							Items: []*fnv1.Resource{},
+						},
+						"obj-1": {
+							Items: []*fnv1.Resource{
+								{
+									Resource: resource.MustStructJSON(`{
+										"apiVersion": "apiextensions.crossplane.io/v1beta1",
+										"kind": "EnvironmentConfig",
+										"metadata": {
+											"name": "my-env-config"
+										}
+									}`),
+								},
+							},
+						},
+						"obj-2": {
+							Items: []*fnv1.Resource{},
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-0",
+									"ref": {
+										"name": "missing-env-config"
+									}
+								},
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-1",
+									"ref": {
+										"name": "my-env-config"
+									}
+								},
+								{
+									"type": "Selector",
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-2",
+									"selector": {
+										"minMatch": 1,
+										"matchLabels": [
+											{
+												"key": "team",
+												"type": "Value",
+												"value": "platform"
+											}
+										]
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_WARNING,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_WARNING,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"obj-0": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "missing-env-config",
+								},
+							},
+							"obj-1": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchName{
+									MatchName: "my-env-config",
+								},
+							},
+							"obj-2": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchLabels{
+									MatchLabels: &fnv1.MatchLabels{
+										Labels: map[string]string{"team": "platform"},
+									},
+								},
+							},
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_FALSE,
+							Reason: "ResolutionFailed",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"InvalidInput": {
+			reason: "The Function should return a single fatal result listing all problems of an invalid input before requesting anything.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								}
+							}`),
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-0",
+									"ref": {
+										"name": "my-env-config"
+									}
+								},
+								{
+									"type": "Selector",
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "obj-0"
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_FALSE,
+							Reason: "ResolutionFailed",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"EmptySelectorMinMatch": {
+			reason: "The Function should return fatal if a skipped empty selector requires a minimum of matches.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								}
+							}`),
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"type": "Selector",
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "skipped",
+									"selector": {
+										"minMatch": 1,
+										"matchLabels": [
+											{
+												"key": "team",
+												"valueFromFieldPath": "spec.team",
+												"fromFieldPathPolicy": "Optional"
+											}
+										]
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_WARNING,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_FALSE,
+							Reason: "ResolutionFailed",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"MinMatchShortfallCondition": {
+			reason: "The Function should set the ExtraResourcesResolved condition to false if a required selector matched too few resources.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								}
+							}`),
+						},
+					},
+					RequiredResources: map[string]*fnv1.Resources{
+						"envs": {
+							Items: []*fnv1.Resource{},
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"type": "Selector",
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "envs",
+									"selector": {
+										"minMatch": 1,
+										"matchLabels": [
+											{
+												"key": "team",
+												"type": "Value",
+												"value": "platform"
+											}
+										]
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_WARNING,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{
+							"envs": {
+								ApiVersion: "apiextensions.crossplane.io/v1beta1",
+								Kind:       "EnvironmentConfig",
+								Match: &fnv1.ResourceSelector_MatchLabels{
+									MatchLabels: &fnv1.MatchLabels{
+										Labels: map[string]string{"team": "platform"},
+									},
+								},
+							},
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_FALSE,
+							Reason: "ResolutionFailed",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"AllSourcesSkipped": {
+			reason: "The Function should write the context if every source is skipped, even though Crossplane sends no required resources then.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "test.crossplane.io/v1alpha1",
+								"kind": "XR",
+								"metadata": {
+									"name": "my-xr"
+								},
+								"spec": {
+									"networking": {
+										"mode": "dedicated"
+									}
+								}
+							}`),
+						},
+					},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+						"kind": "Input",
+						"spec": {
+							"extraResources": [
+								{
+									"kind": "EnvironmentConfig",
+									"apiVersion": "apiextensions.crossplane.io/v1beta1",
+									"into": "vpc",
+									"ref": {
+										"name": "shared-vpc"
+									},
+									"when": {
+										"type": "FieldPathEquals",
+										"fieldPath": "spec.networking.mode",
+										"value": "shared"
+									}
+								}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Requirements: &fnv1.Requirements{
+						Resources: map[string]*fnv1.ResourceSelector{},
+					},
+					Context: &structpb.Struct{
+						Fields: map[string]*structpb.Value{
+							v1beta1.FunctionContextKeyExtraResources: structpb.NewStructValue(resource.MustStructJSON(`{}`)),
+						},
+					},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "ExtraResourcesResolved",
+							Status: fnv1.Status_STATUS_CONDITION_TRUE,
+							Reason: "Resolved",
+							Target: fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			f := &Function{log: logging.NewNopLogger()}
+			rsp, err := f.RunFunction(tc.args.ctx, tc.args.req)
+
+			diff := cmp.Diff(tc.want.rsp, rsp, cmpopts.AcyclicTransformer("toJsonWithoutResultMessages", func(r *fnv1.RunFunctionResponse) []byte {
+				// We don't care about messages.
+				// cmptopts.IgnoreField wasn't working with protocmp.Transform
+				// We can't split this to another transformer as
+				// transformers are applied not in order but as soon as they
+				// match the type, which are walked from the root (RunFunctionResponse).
+				for _, result := range r.GetResults() {
+					result.Message = ""
+				}
+				for _, c := range r.GetConditions() {
+					c.Message = nil
+				}
+				out, err := protojson.Marshal(r)
+				if err != nil {
+					t.Fatalf("cannot marshal %T to JSON: %s", r, err)
+				}
+				return out
+			}))
+			if diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want rsp, +got rsp:\n%s", tc.reason, diff)
+			}
+
+			if diff := cmp.Diff(tc.want.err, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func resourceWithFieldPathValue(path string, value any) resource.Required {
+	u := unstructured.Unstructured{
+		Object: map[string]any{},
+	}
+	err := fieldpath.Pave(u.Object).SetValue(path, value)
+	if err != nil {
+		panic(err)
+	}
+	return resource.Required{
+		Resource: &u,
+	}
+}
+
+func resourceWithFieldPathValues(values map[string]any) resource.Required {
+	u := unstructured.Unstructured{
+		Object: map[string]any{},
+	}
+	for path, value := range values {
+		if err := fieldpath.Pave(u.Object).SetValue(path, value); err != nil {
+			panic(err)
+		}
+	}
+	return resource.Required{
+		Resource: &u,
+	}
+}
+
+func TestSortExtrasByFieldPath(t *testing.T) {
+	type args struct {
+		extras []resource.Required
+		path   string
+	}
+	type want struct {
+		extras []resource.Required
+		err    error
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"SortByString": {
+			reason: "The Function should sort the Extras by the string value at the specified field path",
+			args: args{
+				extras: []resource.Required{
+					resourceWithFieldPathValue("metadata.name", "c"),
+					resourceWithFieldPathValue("metadata.name", "a"),
+					resourceWithFieldPathValue("metadata.name", "b"),
+				},
+				path: "metadata.name",
+			},
+			want: want{
+				extras: []resource.Required{
+					resourceWithFieldPathValue("metadata.name", "a"),
+					resourceWithFieldPathValue("metadata.name", "b"),
+					resourceWithFieldPathValue("metadata.name", "c"),
+				},
+			},
+		},
+		"SortByInt": {
+			reason: "The Function should sort the Extras by the int value at the specified field path",
+			args: args{
+				extras: []resource.Required{
+					resourceWithFieldPathValue("data.someInt", 3),
+					resourceWithFieldPathValue("data.someInt", 1),
+					resourceWithFieldPathValue("data.someInt", 2),
+				},
+				path: "data.someInt",
+			},
+			want: want{
+				extras: []resource.Required{
+					resourceWithFieldPathValue("data.someInt", 1),
+					resourceWithFieldPathValue("data.someInt", 2),
+					resourceWithFieldPathValue("data.someInt", 3),
+				},
+			},
+		},
+		"SortByFloat": {
+			reason: "The Function should sort the Extras by the float value at the specified field path",
+			args: args{
+				extras: []resource.Required{
+					resourceWithFieldPathValue("data.someFloat", 1.3),
+					resourceWithFieldPathValue("data.someFloat", 1.1),
+					resourceWithFieldPathValue("data.someFloat", 1.2),
+					resourceWithFieldPathValue("data.someFloat", 1.4),
+				},
+				path: "data.someFloat",
+			},
+			want: want{
+				extras: []resource.Required{
+					resourceWithFieldPathValue("data.someFloat", 1.1),
+					resourceWithFieldPathValue("data.someFloat", 1.2),
+					resourceWithFieldPathValue("data.someFloat", 1.3),
+					resourceWithFieldPathValue("data.someFloat", 1.4),
+				},
+			},
+		},
+		"InconsistentTypeSortByInt": {
+			reason: "The Function should sort the Extras by the int value at the specified field path",
+			args: args{
+				extras: []resource.Required{
+					resourceWithFieldPathValue("data.someInt", 3),
+					resourceWithFieldPathValue("data.someInt", 1),
+					resourceWithFieldPathValue("data.someInt", "2"),
+				},
+				path: "data.someInt",
+			},
+			want: want{
+				err: cmpopts.AnyError,
+			},
+		},
+		"EmptyPath": {
+			reason: "The Function should return an error if the path is empty",
+			args: args{
+				extras: []resource.Required{
+					resourceWithFieldPathValue("metadata.name", "c"),
+					resourceWithFieldPathValue("metadata.name", "a"),
+					resourceWithFieldPathValue("metadata.name", "b"),
+				},
+				path: "",
+			},
+			want: want{
+				err: cmpopts.AnyError,
+			},
+		},
+		"InvalidPathAll": {
+			reason: "The Function should return no error if the path is invalid for all resources",
+			args: args{
+				extras: []resource.Required{
+					resourceWithFieldPathValue("metadata.name", "c"),
+					resourceWithFieldPathValue("metadata.name", "a"),
+					resourceWithFieldPathValue("metadata.name", "b"),
+				},
+				path: "metadata.invalid",
+			},
+			want: want{
+				extras: []resource.Required{
+					resourceWithFieldPathValue("metadata.name", "c"),
+					resourceWithFieldPathValue("metadata.name", "a"),
+					resourceWithFieldPathValue("metadata.name", "b"),
+				},
+			},
+		},
+		"InvalidPathSome": {
+			reason: "The Function should return no error if the path is invalid for some resources, just use the rest of the resources zero value",
+			args: args{
+				extras: []resource.Required{
+					resourceWithFieldPathValue("metadata.name", "c"),
+					resourceWithFieldPathValue("metadata.invalid", "a"),
+					resourceWithFieldPathValue("metadata.name", "b"),
+				},
+				path: "metadata.name",
+			},
 			want: want{
+				extras: []resource.Required{
+					resourceWithFieldPathValue("metadata.invalid", "a"),
+					resourceWithFieldPathValue("metadata.name", "b"),
+					resourceWithFieldPathValue("metadata.name", "c"),
+				},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := sortExtras(tc.args.extras, []v1beta1.ResourceSourceSelectorSortKey{{FieldPath: tc.args.path}}, v1beta1.MissingKeyPolicyFirst)
+			if diff := cmp.Diff(tc.want.err, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("%s\n(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+			if tc.want.err != nil {
+				return
+			}
+			if diff := cmp.Diff(tc.want.extras, got); diff != "" {
+				t.Errorf("%s\n(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestSortExtras(t *testing.T) {
+	type args struct {
+		extras  []resource.Required
+		keys    []v1beta1.ResourceSourceSelectorSortKey
+		missing v1beta1.MissingKeyPolicy
+	}
+	type want struct {
+		extras []resource.Required
+		err    error
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"SortDescending": {
+			reason: "The Function should sort the Extras in descending order",
+			args: args{
 				extras: []resource.Required{
 					resourceWithFieldPathValue("data.someInt", 1),
+					resourceWithFieldPathValue("data.someInt", 3),
 					resourceWithFieldPathValue("data.someInt", 2),
+				},
+				keys: []v1beta1.ResourceSourceSelectorSortKey{
+					{FieldPath: "data.someInt", Direction: v1beta1.SortDirectionDescending},
+				},
+			},
+			want: want{
+				extras: []resource.Required{
 					resourceWithFieldPathValue("data.someInt", 3),
+					resourceWithFieldPathValue("data.someInt", 2),
+					resourceWithFieldPathValue("data.someInt", 1),
 				},
 			},
 		},
-		"SortByFloat": {
-			reason: "The Function should sort the Extras by the float value at the specified field path",
+		"SortByMultipleKeys": {
+			reason: "The Function should break ties of the first key using the following ones",
 			args: args{
 				extras: []resource.Required{
-					resourceWithFieldPathValue("data.someFloat", 1.3),
-					resourceWithFieldPathValue("data.someFloat", 1.1),
-					resourceWithFieldPathValue("data.someFloat", 1.2),
-					resourceWithFieldPathValue("data.someFloat", 1.4),
+					resourceWithFieldPathValues(map[string]any{"data.priority": 1, "metadata.name": "a"}),
+					resourceWithFieldPathValues(map[string]any{"data.priority": 2, "metadata.name": "c"}),
+					resourceWithFieldPathValues(map[string]any{"data.priority": 2, "metadata.name": "b"}),
+				},
+				keys: []v1beta1.ResourceSourceSelectorSortKey{
+					{FieldPath: "data.priority", Direction: v1beta1.SortDirectionDescending},
+					{FieldPath: "metadata.name"},
 				},
-				path: "data.someFloat",
 			},
 			want: want{
 				extras: []resource.Required{
-					resourceWithFieldPathValue("data.someFloat", 1.1),
-					resourceWithFieldPathValue("data.someFloat", 1.2),
-					resourceWithFieldPathValue("data.someFloat", 1.3),
-					resourceWithFieldPathValue("data.someFloat", 1.4),
+					resourceWithFieldPathValues(map[string]any{"data.priority": 2, "metadata.name": "b"}),
+					resourceWithFieldPathValues(map[string]any{"data.priority": 2, "metadata.name": "c"}),
+					resourceWithFieldPathValues(map[string]any{"data.priority": 1, "metadata.name": "a"}),
 				},
 			},
 		},
-		"InconsistentTypeSortByInt": {
-			reason: "The Function should sort the Extras by the int value at the specified field path",
+		"SortIsStable": {
+			reason: "The Function should keep the original order of Extras with equal keys",
 			args: args{
 				extras: []resource.Required{
-					resourceWithFieldPathValue("data.someInt", 3),
-					resourceWithFieldPathValue("data.someInt", 1),
-					resourceWithFieldPathValue("data.someInt", "2"),
+					resourceWithFieldPathValues(map[string]any{"data.priority": 1, "metadata.name": "c"}),
+					resourceWithFieldPathValues(map[string]any{"data.priority": 2, "metadata.name": "b"}),
+					resourceWithFieldPathValues(map[string]any{"data.priority": 1, "metadata.name": "a"}),
+				},
+				keys: []v1beta1.ResourceSourceSelectorSortKey{
+					{FieldPath: "data.priority"},
 				},
-				path: "data.someInt",
 			},
 			want: want{
-				err: cmpopts.AnyError,
+				extras: []resource.Required{
+					resourceWithFieldPathValues(map[string]any{"data.priority": 1, "metadata.name": "c"}),
+					resourceWithFieldPathValues(map[string]any{"data.priority": 1, "metadata.name": "a"}),
+					resourceWithFieldPathValues(map[string]any{"data.priority": 2, "metadata.name": "b"}),
+				},
 			},
 		},
-		"EmptyPath": {
-			reason: "The Function should return an error if the path is empty",
+		"SortAsSemVer": {
+			reason: "The Function should sort the Extras by semantic version rather than lexically",
 			args: args{
 				extras: []resource.Required{
-					resourceWithFieldPathValue("metadata.name", "c"),
-					resourceWithFieldPathValue("metadata.name", "a"),
-					resourceWithFieldPathValue("metadata.name", "b"),
+					resourceWithFieldPathValue("data.version", "v1.10.0"),
+					resourceWithFieldPathValue("data.version", "1.9.2"),
+					resourceWithFieldPathValue("data.version", "v1.9"),
+				},
+				keys: []v1beta1.ResourceSourceSelectorSortKey{
+					{FieldPath: "data.version", SortAs: v1beta1.SortAsSemVer},
+				},
+			},
+			want: want{
+				extras: []resource.Required{
+					resourceWithFieldPathValue("data.version", "v1.9"),
+					resourceWithFieldPathValue("data.version", "1.9.2"),
+					resourceWithFieldPathValue("data.version", "v1.10.0"),
+				},
+			},
+		},
+		"SortAsQuantity": {
+			reason: "The Function should sort the Extras by Kubernetes quantity",
+			args: args{
+				extras: []resource.Required{
+					resourceWithFieldPathValue("data.memory", "1Gi"),
+					resourceWithFieldPathValue("data.memory", "512Mi"),
+					resourceWithFieldPathValue("data.memory", int64(2000000000)),
+				},
+				keys: []v1beta1.ResourceSourceSelectorSortKey{
+					{FieldPath: "data.memory", SortAs: v1beta1.SortAsQuantity, Direction: v1beta1.SortDirectionDescending},
+				},
+			},
+			want: want{
+				extras: []resource.Required{
+					resourceWithFieldPathValue("data.memory", int64(2000000000)),
+					resourceWithFieldPathValue("data.memory", "1Gi"),
+					resourceWithFieldPathValue("data.memory", "512Mi"),
+				},
+			},
+		},
+		"SortAsTimestamp": {
+			reason: "The Function should sort the Extras by timestamp regardless of the time zone",
+			args: args{
+				extras: []resource.Required{
+					resourceWithFieldPathValue("metadata.creationTimestamp", "2024-01-01T12:00:00Z"),
+					resourceWithFieldPathValue("metadata.creationTimestamp", "2024-01-01T13:00:00+02:00"),
+				},
+				keys: []v1beta1.ResourceSourceSelectorSortKey{
+					{FieldPath: "metadata.creationTimestamp", SortAs: v1beta1.SortAsTimestamp},
+				},
+			},
+			want: want{
+				extras: []resource.Required{
+					resourceWithFieldPathValue("metadata.creationTimestamp", "2024-01-01T13:00:00+02:00"),
+					resourceWithFieldPathValue("metadata.creationTimestamp", "2024-01-01T12:00:00Z"),
+				},
+			},
+		},
+		"SortAsNumber": {
+			reason: "The Function should parse strings as numbers",
+			args: args{
+				extras: []resource.Required{
+					resourceWithFieldPathValue("data.weight", "10"),
+					resourceWithFieldPathValue("data.weight", "9.5"),
+				},
+				keys: []v1beta1.ResourceSourceSelectorSortKey{
+					{FieldPath: "data.weight", SortAs: v1beta1.SortAsNumber},
+				},
+			},
+			want: want{
+				extras: []resource.Required{
+					resourceWithFieldPathValue("data.weight", "9.5"),
+					resourceWithFieldPathValue("data.weight", "10"),
+				},
+			},
+		},
+		"SortByBoolean": {
+			reason: "The Function should sort false before true",
+			args: args{
+				extras: []resource.Required{
+					resourceWithFieldPathValue("data.preferred", true),
+					resourceWithFieldPathValue("data.preferred", "false"),
+				},
+				keys: []v1beta1.ResourceSourceSelectorSortKey{
+					{FieldPath: "data.preferred", SortAs: v1beta1.SortAsBoolean},
+				},
+			},
+			want: want{
+				extras: []resource.Required{
+					resourceWithFieldPathValue("data.preferred", "false"),
+					resourceWithFieldPathValue("data.preferred", true),
+				},
+			},
+		},
+		"InvalidSemVer": {
+			reason: "The Function should return an error if a value cannot be parsed as specified",
+			args: args{
+				extras: []resource.Required{
+					resourceWithFieldPathValue("data.version", "latest"),
+				},
+				keys: []v1beta1.ResourceSourceSelectorSortKey{
+					{FieldPath: "data.version", SortAs: v1beta1.SortAsSemVer},
 				},
-				path: "",
 			},
 			want: want{
 				err: cmpopts.AnyError,
 			},
 		},
-		"InvalidPathAll": {
-			reason: "The Function should return no error if the path is invalid for all resources",
+		"MissingKeyFirst": {
+			reason: "The Function should sort Extras missing a key before all others, even when sorting in descending order",
 			args: args{
 				extras: []resource.Required{
-					resourceWithFieldPathValue("metadata.name", "c"),
-					resourceWithFieldPathValue("metadata.name", "a"),
-					resourceWithFieldPathValue("metadata.name", "b"),
+					resourceWithFieldPathValues(map[string]any{"data.priority": 1, "metadata.name": "a"}),
+					resourceWithFieldPathValues(map[string]any{"metadata.name": "b"}),
+					resourceWithFieldPathValues(map[string]any{"data.priority": 0, "metadata.name": "c"}),
 				},
-				path: "metadata.invalid",
+				keys: []v1beta1.ResourceSourceSelectorSortKey{
+					{FieldPath: "data.priority", Direction: v1beta1.SortDirectionDescending},
+				},
+				missing: v1beta1.MissingKeyPolicyFirst,
 			},
 			want: want{
 				extras: []resource.Required{
-					resourceWithFieldPathValue("metadata.name", "c"),
-					resourceWithFieldPathValue("metadata.name", "a"),
-					resourceWithFieldPathValue("metadata.name", "b"),
+					resourceWithFieldPathValues(map[string]any{"metadata.name": "b"}),
+					resourceWithFieldPathValues(map[string]any{"data.priority": 1, "metadata.name": "a"}),
+					resourceWithFieldPathValues(map[string]any{"data.priority": 0, "metadata.name": "c"}),
 				},
 			},
 		},
-		"InvalidPathSome": {
-			reason: "The Function should return no error if the path is invalid for some resources, just use the rest of the resources zero value",
+		"MissingKeyLast": {
+			reason: "The Function should sort Extras missing a key after all others rather than as zero values",
 			args: args{
 				extras: []resource.Required{
-					resourceWithFieldPathValue("metadata.name", "c"),
-					resourceWithFieldPathValue("metadata.invalid", "a"),
-					resourceWithFieldPathValue("metadata.name", "b"),
+					resourceWithFieldPathValues(map[string]any{"metadata.name": "b"}),
+					resourceWithFieldPathValues(map[string]any{"data.priority": 1, "metadata.name": "a"}),
+					resourceWithFieldPathValues(map[string]any{"data.priority": 0, "metadata.name": "c"}),
 				},
-				path: "metadata.name",
+				keys: []v1beta1.ResourceSourceSelectorSortKey{
+					{FieldPath: "data.priority"},
+				},
+				missing: v1beta1.MissingKeyPolicyLast,
 			},
 			want: want{
 				extras: []resource.Required{
-					resourceWithFieldPathValue("metadata.invalid", "a"),
-					resourceWithFieldPathValue("metadata.name", "b"),
-					resourceWithFieldPathValue("metadata.name", "c"),
+					resourceWithFieldPathValues(map[string]any{"data.priority": 0, "metadata.name": "c"}),
+					resourceWithFieldPathValues(map[string]any{"data.priority": 1, "metadata.name": "a"}),
+					resourceWithFieldPathValues(map[string]any{"metadata.name": "b"}),
+				},
+			},
+		},
+		"MissingKeyExclude": {
+			reason: "The Function should drop Extras missing any of the keys",
+			args: args{
+				extras: []resource.Required{
+					resourceWithFieldPathValues(map[string]any{"data.priority": 1, "metadata.name": "a"}),
+					resourceWithFieldPathValues(map[string]any{"metadata.name": "b"}),
+					resourceWithFieldPathValues(map[string]any{"data.priority": 0}),
+				},
+				keys: []v1beta1.ResourceSourceSelectorSortKey{
+					{FieldPath: "data.priority"},
+					{FieldPath: "metadata.name"},
+				},
+				missing: v1beta1.MissingKeyPolicyExclude,
+			},
+			want: want{
+				extras: []resource.Required{
+					resourceWithFieldPathValues(map[string]any{"data.priority": 1, "metadata.name": "a"}),
+				},
+			},
+		},
+		"MissingKeyError": {
+			reason: "The Function should return an error if an Extra is missing a key",
+			args: args{
+				extras: []resource.Required{
+					resourceWithFieldPathValues(map[string]any{"data.priority": 1, "metadata.name": "a"}),
+					resourceWithFieldPathValues(map[string]any{"metadata.name": "b"}),
+				},
+				keys: []v1beta1.ResourceSourceSelectorSortKey{
+					{FieldPath: "data.priority"},
+				},
+				missing: v1beta1.MissingKeyPolicyError,
+			},
+			want: want{
+				err: cmpopts.AnyError,
+			},
+		},
+		"EmptyPath": {
+			reason: "The Function should return an error if the path of any key is empty",
+			args: args{
+				extras: []resource.Required{
+					resourceWithFieldPathValue("metadata.name", "a"),
+				},
+				keys: []v1beta1.ResourceSourceSelectorSortKey{
+					{FieldPath: "metadata.name"},
+					{FieldPath: ""},
 				},
 			},
+			want: want{
+				err: cmpopts.AnyError,
+			},
 		},
 	}
 
 	for name, tc := range cases {
 		t.Run(name, func(t *testing.T) {
-			got := sortExtrasByFieldPath(tc.args.extras, tc.args.path)
-			if diff := cmp.Diff(tc.want.err, got, cmpopts.EquateErrors()); diff != "" {
+			got, err := sortExtras(tc.args.extras, tc.args.keys, tc.args.missing)
+			if diff := cmp.Diff(tc.want.err, err, cmpopts.EquateErrors()); diff != "" {
 				t.Errorf("%s\n(...): -want err, +got err:\n%s", tc.reason, diff)
 			}
 			if tc.want.err != nil {
 				return
 			}
-			if diff := cmp.Diff(tc.want.extras, tc.args.extras); diff != "" {
+			if diff := cmp.Diff(tc.want.extras, got); diff != "" {
+				t.Errorf("%s\n(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestTransformLabelValue(t *testing.T) {
+	type args struct {
+		value      string
+		transforms []v1beta1.LabelValueTransform
+	}
+	type want struct {
+		value string
+		err   error
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"Uppercase": {
+			reason: "The value should be uppercased",
+			args: args{
+				value:      "eu-west",
+				transforms: []v1beta1.LabelValueTransform{{Type: v1beta1.LabelValueTransformTypeUppercase}},
+			},
+			want: want{
+				value: "EU-WEST",
+			},
+		},
+		"Suffix": {
+			reason: "The suffix should be appended to the value",
+			args: args{
+				value:      "eu",
+				transforms: []v1beta1.LabelValueTransform{{Type: v1beta1.LabelValueTransformTypeSuffix, Value: "-defaults"}},
+			},
+			want: want{
+				value: "eu-defaults",
+			},
+		},
+		"TruncateShortValue": {
+			reason: "Values no longer than the length should be left as they are",
+			args: args{
+				value:      "short",
+				transforms: []v1beta1.LabelValueTransform{{Type: v1beta1.LabelValueTransformTypeTruncate}},
+			},
+			want: want{
+				value: "short",
+			},
+		},
+		"TruncateLongValue": {
+			reason: "Values longer than the length should be truncated and suffixed with a hash of the original value",
+			args: args{
+				value:      strings.Repeat("a", 70),
+				transforms: []v1beta1.LabelValueTransform{{Type: v1beta1.LabelValueTransformTypeTruncate}},
+			},
+			want: want{
+				value: strings.Repeat("a", 54) + "-6bd5e503",
+			},
+		},
+		"MapMissingKey": {
+			reason: "Values not in the map should return an error",
+			args: args{
+				value:      "Staging",
+				transforms: []v1beta1.LabelValueTransform{{Type: v1beta1.LabelValueTransformTypeMap, Map: map[string]string{"Production": "prod"}}},
+			},
+			want: want{
+				err: cmpopts.AnyError,
+			},
+		},
+		"InvalidPattern": {
+			reason: "Invalid regular expressions should return an error",
+			args: args{
+				value: "eu",
+				transforms: []v1beta1.LabelValueTransform{{
+					Type:    v1beta1.LabelValueTransformTypeReplace,
+					Replace: &v1beta1.LabelValueReplace{Pattern: "("},
+				}},
+			},
+			want: want{
+				err: cmpopts.AnyError,
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := transformLabelValue(tc.args.value, tc.args.transforms)
+			if diff := cmp.Diff(tc.want.err, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("%s\n(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.value, got); diff != "" {
 				t.Errorf("%s\n(...): -want, +got:\n%s", tc.reason, diff)
 			}
 		})
 	}
 }
+
+func TestSelectionMessage(t *testing.T) {
+	type args struct {
+		selector *v1beta1.ResourceSourceSelector
+		selected []resource.Required
+		dropped  []resource.Required
+	}
+
+	named := func(names ...string) []resource.Required {
+		extras := make([]resource.Required, len(names))
+		for i, n := range names {
+			extras[i] = resource.Required{Resource: &unstructured.Unstructured{Object: map[string]any{"metadata": map[string]any{"name": n}}}}
+		}
+		return extras
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   string
+	}{
+		"NoLimits": {
+			reason: "Selectors without limits should report them as none",
+			args: args{
+				selector: &v1beta1.ResourceSourceSelector{},
+				selected: named("a", "b"),
+			},
+			want: `Selected 2 of 2 matching extra resources "obj" (minMatch none, maxMatch none): a, b`,
+		},
+		"NothingSelected": {
+			reason: "Selectors that selected nothing should say so",
+			args: args{
+				selector: &v1beta1.ResourceSourceSelector{MinMatch: ptr.To[uint64](0)},
+			},
+			want: `Selected 0 of 0 matching extra resources "obj" (minMatch 0, maxMatch none): none`,
+		},
+		"DroppedByMaxMatch": {
+			reason: "Resources dropped by maxMatch should be listed",
+			args: args{
+				selector: &v1beta1.ResourceSourceSelector{MinMatch: ptr.To[uint64](1), MaxMatch: ptr.To[uint64](1)},
+				selected: named("a"),
+				dropped:  named("b", "c"),
+			},
+			want: `Selected 1 of 3 matching extra resources "obj" (minMatch 1, maxMatch 1): a; dropped by maxMatch: b, c`,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := selectionMessage("obj", tc.args.selector, tc.args.selected, tc.args.dropped)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s\nselectionMessage(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestSourceErrors(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		errs   sourceErrors
+		want   string
+	}{
+		"Single": {
+			reason: "A single error should be reported with the index and into key of its source",
+			errs: sourceErrors{
+				{index: 1, into: "obj-1", err: errors.New("boom")},
+			},
+			want: `extraResources[1] ("obj-1"): boom`,
+		},
+		"Multiple": {
+			reason: "Multiple errors should be counted and joined",
+			errs: sourceErrors{
+				{index: 0, into: "obj-0", err: errors.New("boom")},
+				{index: 2, into: "obj-2", err: errors.New("bang")},
+			},
+			want: `2 extra resources failed: extraResources[0] ("obj-0"): boom; extraResources[2] ("obj-2"): bang`,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, tc.errs.Error()); diff != "" {
+				t.Errorf("%s\nError(): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
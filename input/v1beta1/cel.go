@@ -0,0 +1,35 @@
+package v1beta1
+
+import (
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"github.com/google/cel-go/cel"
+)
+
+const (
+	// CELVarObject is the CEL variable an extra resource is bound to.
+	CELVarObject = "object"
+	// CELVarXR is the CEL variable the observed composite resource is bound
+	// to.
+	CELVarXR = "xr"
+)
+
+// CompileCELExpression compiles a CEL expression that must evaluate to a
+// boolean.
+func CompileCELExpression(expr string) (cel.Program, error) {
+	env, err := cel.NewEnv(
+		cel.Variable(CELVarObject, cel.DynType),
+		cel.Variable(CELVarXR, cel.DynType),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create CEL environment")
+	}
+	ast, iss := env.Compile(expr)
+	if iss.Err() != nil {
+		return nil, errors.Wrapf(iss.Err(), "cannot compile CEL expression %q", expr)
+	}
+	if t := ast.OutputType(); t != cel.BoolType && t != cel.DynType {
+		return nil, errors.Errorf("CEL expression %q must evaluate to a bool, not %s", expr, t)
+	}
+	prg, err := env.Program(ast)
+	return prg, errors.Wrapf(err, "cannot create CEL program for expression %q", expr)
+}
@@ -17,6 +17,9 @@ limitations under the License.
 */
 
 import (
+	"fmt"
+	"slices"
+
 	xpv1 "github.com/crossplane/crossplane-runtime/v2/apis/common/v1"
 )
 
@@ -33,10 +36,23 @@ type InputSpec struct {
 	// ExtraResources selects a list of `ExtraResource`s. The resolved
 	// resources are stored in the composite resource at
 	// `spec.extraResourceRefs` and is only updated if it is null.
+	// +listType=map
+	// +listMapKey=into
 	ExtraResources []ResourceSource `json:"extraResources"`
 
+	// Merge deep merges a field of all resolved extra resources into a single
+	// object that is written to the context key instead of the resources
+	// themselves, like the environment of native Crossplane
+	// EnvironmentConfigs. Resources are merged in the order of
+	// ExtraResources, and within a source in the order they are sorted in.
+	// Later resources take precedence. Fields and OutputShape of the sources
+	// are ignored.
+	// +optional
+	Merge *Merge `json:"merge,omitempty"`
+
 	// Policy represents the Resolution policies which apply to all
-	// ResourceSourceReferences in ExtraResources list.
+	// ResourceSourceReferences in ExtraResources list that don't specify a
+	// policy of their own.
 	// +optional
 	Policy *Policy `json:"policy,omitempty"`
 }
@@ -48,6 +64,16 @@ type Context struct {
 	// standard functions such as Function Patch and Transform.
 	// +kubebuilder:default=apiextensions.crossplane.io/extra-resources
 	Key *string `json:"key,omitempty"`
+
+	// Mode specifies how resolved extra resources are written to the context
+	// key. Replace overwrites whatever is stored at the key. MergeShallow
+	// keeps the existing object at the key and overwrites its top level
+	// fields. MergeDeep recursively merges into the existing object; lists
+	// and other values are overwritten.
+	// +optional
+	// +kubebuilder:validation:Enum=Replace;MergeShallow;MergeDeep
+	// +kubebuilder:default=Replace
+	Mode ContextMode `json:"mode,omitempty"`
 }
 
 // GetKey returns the key of the context, defaulting to
@@ -59,6 +85,46 @@ func (i *Context) GetKey() string {
 	return *i.Key
 }
 
+// GetMode returns the mode of the context, defaulting to ContextModeReplace
+// if not specified.
+func (i *Context) GetMode() ContextMode {
+	if i == nil || i.Mode == "" {
+		return ContextModeReplace
+	}
+	return i.Mode
+}
+
+// ContextMode specifies how resolved extra resources are written to the
+// context key.
+type ContextMode string
+
+const (
+	// ContextModeReplace overwrites the context key.
+	ContextModeReplace ContextMode = "Replace"
+	// ContextModeMergeShallow merges into the top level of the context key.
+	ContextModeMergeShallow ContextMode = "MergeShallow"
+	// ContextModeMergeDeep recursively merges into the context key.
+	ContextModeMergeDeep ContextMode = "MergeDeep"
+)
+
+// A Merge specifies how resolved extra resources are merged into a single
+// object.
+type Merge struct {
+	// FieldPath is the field path of the extra resources to merge. Resources
+	// that don't have the field are skipped.
+	// +optional
+	// +kubebuilder:default=data
+	FieldPath string `json:"fieldPath,omitempty"`
+}
+
+// GetFieldPath returns the field path to merge if set or a sane default.
+func (m *Merge) GetFieldPath() string {
+	if m == nil || m.FieldPath == "" {
+		return "data"
+	}
+	return m.FieldPath
+}
+
 // Policy represents the Resolution policy of Reference instance.
 type Policy struct {
 	// Resolution specifies whether resolution of this reference is required.
@@ -91,7 +157,23 @@ const (
 	ResourceSourceTypeSelector ResourceSourceType = "Selector"
 )
 
+// ResourceSourceOutputShape specifies how the extra resources of a source are
+// put into the context.
+type ResourceSourceOutputShape string
+
+const (
+	// ResourceSourceOutputShapeList puts a list of extra resources.
+	ResourceSourceOutputShapeList ResourceSourceOutputShape = "List"
+	// ResourceSourceOutputShapeObject puts a single extra resource.
+	ResourceSourceOutputShapeObject ResourceSourceOutputShape = "Object"
+	// ResourceSourceOutputShapeMap puts a map of extra resources keyed by a
+	// field path.
+	ResourceSourceOutputShapeMap ResourceSourceOutputShape = "Map"
+)
+
 // ResourceSource selects a ExtraResource.
+// +kubebuilder:validation:XValidation:rule="(has(self.type) && self.type == 'Selector') || has(self.ref)",message="ref is required for type Reference"
+// +kubebuilder:validation:XValidation:rule="!has(self.type) || self.type != 'Selector' || has(self.selector)",message="selector is required for type Selector"
 type ResourceSource struct {
 	// Type specifies the way the ExtraResource is selected.
 	// Default is `Reference`
@@ -116,12 +198,71 @@ type ResourceSource struct {
 	APIVersion string `json:"apiVersion,omitempty"`
 
 	// Namespace is the namespace in which to look for the ExtraResource.
-	// If not set, the resource is assumed to be cluster-scoped.
+	// If neither Namespace nor NamespaceFrom is set, the resource is assumed
+	// to be cluster-scoped.
 	// +optional
 	Namespace *string `json:"namespace,omitempty"`
 
+	// NamespaceFrom specifies where the namespace in which to look for the
+	// ExtraResource comes from. Takes precedence over Namespace.
+	// +optional
+	NamespaceFrom *ResourceSourceNamespace `json:"namespaceFrom,omitempty"`
+
 	// Into is the key into which extra resources for this selector will be placed.
 	Into string `json:"into"`
+
+	// Fields is a list of field paths of the extra resources to put into the
+	// context, e.g. 'metadata.name' or 'data'. Fields that are not set are
+	// skipped. All fields are kept if empty.
+	// +optional
+	Fields []string `json:"fields,omitempty"`
+
+	// StripManagedFields removes 'metadata.managedFields' from the extra
+	// resources before they are put into the context.
+	// +optional
+	// +kubebuilder:default=true
+	StripManagedFields *bool `json:"stripManagedFields,omitempty"`
+
+	// OutputShape specifies how the extra resources are put into the context.
+	// List puts a list of all resources, Object puts the single resource and
+	// fails if there is more than one, Map puts a map of the resources keyed
+	// by the value at OutputKeyFieldPath.
+	// +optional
+	// +kubebuilder:validation:Enum=List;Object;Map
+	// +kubebuilder:default=List
+	OutputShape ResourceSourceOutputShape `json:"outputShape,omitempty"`
+
+	// OutputKeyFieldPath is the field path of the extra resources whose value
+	// is used as the key for the Map output shape.
+	// +optional
+	// +kubebuilder:default="metadata.name"
+	OutputKeyFieldPath string `json:"outputKeyFieldPath,omitempty"`
+
+	// ToCompositeFieldPaths writes values of the resolved extra resources to
+	// the status of the desired composite resource, e.g. to show which
+	// resources a composite resource resolved to.
+	// +optional
+	ToCompositeFieldPaths []ResourceSourceToCompositeFieldPath `json:"toCompositeFieldPaths,omitempty"`
+
+	// Filter is a CEL expression evaluated against each extra resource,
+	// bound to the variable "object", with the observed composite resource
+	// bound to "xr". Only resources it evaluates to true for are kept, before
+	// MinMatch and MaxMatch are applied.
+	// +optional
+	Filter *string `json:"filter,omitempty"`
+
+	// When is a condition on the observed composite resource. If set, the
+	// source is only requested while it holds, and left out of the output
+	// otherwise.
+	// +optional
+	When *ResourceSourceCondition `json:"when,omitempty"`
+
+	// Policy represents the Resolution policy of this ResourceSource. For a
+	// Reference it overrides the policy of the spec. For a Selector, an
+	// Optional policy turns a MinMatch shortfall into an empty list and a
+	// warning instead of an error.
+	// +optional
+	Policy *Policy `json:"policy,omitempty"`
 }
 
 // GetType returns the type of the resource source, returning the default if not set.
@@ -132,13 +273,283 @@ func (e *ResourceSource) GetType() ResourceSourceType {
 	return e.Type
 }
 
+// A ResourceSourceToCompositeFieldPath writes a value of the resolved extra
+// resources to the desired composite resource. The value is a list with the
+// value of each resource that has the field, or the value of the single
+// resource if the OutputShape of the source is Object.
+type ResourceSourceToCompositeFieldPath struct {
+	// FromFieldPath is the field path of the extra resources to read.
+	// +optional
+	// +kubebuilder:default="metadata.name"
+	FromFieldPath string `json:"fromFieldPath,omitempty"`
+
+	// ToFieldPath is the field path of the composite resource to write to.
+	// It must be within the status of the composite resource.
+	ToFieldPath string `json:"toFieldPath"`
+}
+
+// GetFromFieldPath returns the field path to read if set or a sane default.
+func (e *ResourceSourceToCompositeFieldPath) GetFromFieldPath() string {
+	if e == nil || e.FromFieldPath == "" {
+		return "metadata.name"
+	}
+	return e.FromFieldPath
+}
+
+// ResourceSourceNamespaceType specifies where the namespace of an
+// ExtraResource comes from.
+type ResourceSourceNamespaceType string
+
+const (
+	// ResourceSourceNamespaceTypeValue uses a literal namespace.
+	ResourceSourceNamespaceTypeValue ResourceSourceNamespaceType = "Value"
+	// ResourceSourceNamespaceTypeFromCompositeFieldPath extracts the namespace
+	// from a composite fieldpath.
+	ResourceSourceNamespaceTypeFromCompositeFieldPath ResourceSourceNamespaceType = "FromCompositeFieldPath"
+	// ResourceSourceNamespaceTypeXRNamespace uses the namespace of the
+	// composite resource.
+	ResourceSourceNamespaceTypeXRNamespace ResourceSourceNamespaceType = "XRNamespace"
+)
+
+// A ResourceSourceNamespace specifies the namespace of an ExtraResource.
+// The namespace must always resolve, there is no fallback to a cluster-scoped
+// lookup.
+type ResourceSourceNamespace struct {
+	// Type specifies where the namespace comes from.
+	// +optional
+	// +kubebuilder:validation:Enum=Value;FromCompositeFieldPath;XRNamespace
+	// +kubebuilder:default=XRNamespace
+	Type ResourceSourceNamespaceType `json:"type,omitempty"`
+
+	// Value specifies a literal namespace.
+	// +optional
+	Value *string `json:"value,omitempty"`
+
+	// ValueFromFieldPath specifies the field path of the composite resource
+	// to look for the namespace.
+	// +optional
+	ValueFromFieldPath *string `json:"valueFromFieldPath,omitempty"`
+}
+
+// GetType returns the type of the namespace, returning the default if not set.
+func (e *ResourceSourceNamespace) GetType() ResourceSourceNamespaceType {
+	if e == nil || e.Type == "" {
+		return ResourceSourceNamespaceTypeXRNamespace
+	}
+	return e.Type
+}
+
+// ResourceSourceConditionType specifies how a condition is evaluated.
+type ResourceSourceConditionType string
+
+const (
+	// ResourceSourceConditionTypeFieldPathExists holds if the field path of
+	// the composite resource is set.
+	ResourceSourceConditionTypeFieldPathExists ResourceSourceConditionType = "FieldPathExists"
+	// ResourceSourceConditionTypeFieldPathEquals holds if the field path of
+	// the composite resource is set to the given value.
+	ResourceSourceConditionTypeFieldPathEquals ResourceSourceConditionType = "FieldPathEquals"
+	// ResourceSourceConditionTypeExpression holds if the CEL expression
+	// evaluates to true.
+	ResourceSourceConditionTypeExpression ResourceSourceConditionType = "Expression"
+)
+
+// A ResourceSourceCondition is a predicate on the observed composite resource.
+type ResourceSourceCondition struct {
+	// Type specifies how the condition is evaluated.
+	// +kubebuilder:validation:Enum=FieldPathExists;FieldPathEquals;Expression
+	Type ResourceSourceConditionType `json:"type"`
+
+	// FieldPath of the composite resource to check, for types
+	// FieldPathExists and FieldPathEquals.
+	// +optional
+	FieldPath *string `json:"fieldPath,omitempty"`
+
+	// Value the field path must be equal to for type FieldPathEquals.
+	// Booleans and numbers are compared by their string form, e.g. "true".
+	// +optional
+	Value *string `json:"value,omitempty"`
+
+	// Expression is a CEL expression for type Expression, with the observed
+	// composite resource bound to the variable "xr".
+	// +optional
+	Expression *string `json:"expression,omitempty"`
+}
+
+// ResourceSourceReferenceType specifies where the name of a referenced
+// ExtraResource comes from.
+type ResourceSourceReferenceType string
+
+const (
+	// ResourceSourceReferenceTypeName uses a literal name.
+	ResourceSourceReferenceTypeName ResourceSourceReferenceType = "Name"
+	// ResourceSourceReferenceTypeFromCompositeFieldPath extracts the name from
+	// a composite fieldpath.
+	ResourceSourceReferenceTypeFromCompositeFieldPath ResourceSourceReferenceType = "FromCompositeFieldPath"
+	// ResourceSourceReferenceTypeFromExtraResourceFieldPath extracts the name
+	// from a fieldpath of the resource another source resolved to.
+	ResourceSourceReferenceTypeFromExtraResourceFieldPath ResourceSourceReferenceType = "FromExtraResourceFieldPath"
+	// ResourceSourceReferenceTypeTemplate renders the name from a template
+	// over the composite resource.
+	ResourceSourceReferenceTypeTemplate ResourceSourceReferenceType = "Template"
+	// ResourceSourceReferenceTypeCandidates requests a list of candidate
+	// names at once and uses the first one that exists.
+	ResourceSourceReferenceTypeCandidates ResourceSourceReferenceType = "Candidates"
+)
+
+// GetDependencies returns the Into keys of the sources whose resolved
+// resources this source draws values from.
+func (e *ResourceSource) GetDependencies() []string {
+	var deps []string
+	if e.Ref != nil && e.Ref.GetType() == ResourceSourceReferenceTypeFromExtraResourceFieldPath {
+		deps = append(deps, e.Ref.FromExtraResource)
+	}
+	if e.Selector != nil {
+		for _, m := range e.Selector.MatchLabels {
+			if m.GetType() == ResourceSourceSelectorLabelMatcherTypeFromExtraResourceFieldPath && !slices.Contains(deps, m.FromExtraResource) {
+				deps = append(deps, m.FromExtraResource)
+			}
+		}
+	}
+	return deps
+}
+
+// GetCandidateKey returns the key under which the candidate at the given index
+// of a Candidates reference is requested.
+func (e *ResourceSource) GetCandidateKey(i int) string {
+	return fmt.Sprintf("%s[%d]", e.Into, i)
+}
+
+// GetOutputShape returns the output shape of the source, returning the
+// default if not set.
+func (e *ResourceSource) GetOutputShape() ResourceSourceOutputShape {
+	if e == nil || e.OutputShape == "" {
+		return ResourceSourceOutputShapeList
+	}
+	return e.OutputShape
+}
+
+// GetOutputKeyFieldPath returns the key path of the Map output shape if set or
+// a sane default.
+func (e *ResourceSource) GetOutputKeyFieldPath() string {
+	if e == nil || e.OutputKeyFieldPath == "" {
+		return "metadata.name"
+	}
+	return e.OutputKeyFieldPath
+}
+
+// GetStripManagedFields returns whether managed fields should be removed from
+// the extra resources, defaulting to true if not set.
+func (e *ResourceSource) GetStripManagedFields() bool {
+	if e == nil || e.StripManagedFields == nil {
+		return true
+	}
+	return *e.StripManagedFields
+}
+
+// IsResolutionPolicyOptional checks whether the resolution policy of the
+// source is Optional. Reference sources without a policy of their own fall
+// back to the supplied spec level policy.
+func (e *ResourceSource) IsResolutionPolicyOptional(spec *Policy) bool {
+	if e.Policy != nil && e.Policy.Resolution != nil {
+		return e.Policy.IsResolutionPolicyOptional()
+	}
+	if e.GetType() == ResourceSourceTypeReference {
+		return spec.IsResolutionPolicyOptional()
+	}
+	return false
+}
+
 // An ResourceSourceReference references an ExtraResource by it's name.
+// +kubebuilder:validation:XValidation:rule="(has(self.type) && self.type != 'Name') || (has(self.name) && size(self.name) > 0)",message="name is required for type Name"
+// +kubebuilder:validation:XValidation:rule="!has(self.type) || !(self.type in ['FromCompositeFieldPath', 'FromExtraResourceFieldPath']) || has(self.nameFromFieldPath)",message="nameFromFieldPath is required for types FromCompositeFieldPath and FromExtraResourceFieldPath"
+// +kubebuilder:validation:XValidation:rule="!has(self.type) || self.type != 'FromExtraResourceFieldPath' || has(self.fromExtraResource)",message="fromExtraResource is required for type FromExtraResourceFieldPath"
+// +kubebuilder:validation:XValidation:rule="!has(self.type) || self.type != 'Template' || has(self.nameTemplate)",message="nameTemplate is required for type Template"
+// +kubebuilder:validation:XValidation:rule="!has(self.type) || self.type != 'Candidates' || (has(self.candidates) && size(self.candidates) > 0)",message="candidates are required for type Candidates"
 type ResourceSourceReference struct {
+	// Type specifies where the name of the object comes from.
+	// +optional
+	// +kubebuilder:validation:Enum=Name;FromCompositeFieldPath;FromExtraResourceFieldPath;Template;Candidates
+	// +kubebuilder:default=Name
+	Type ResourceSourceReferenceType `json:"type,omitempty"`
+
 	// The name of the object.
-	Name string `json:"name"`
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// NameFromFieldPath specifies the field path of the composite resource,
+	// or of the extra resource for type FromExtraResourceFieldPath, to look
+	// for the name of the object.
+	// +optional
+	NameFromFieldPath *string `json:"nameFromFieldPath,omitempty"`
+
+	// FromExtraResource is the Into key of the source whose resolved resource
+	// the name is drawn from for type FromExtraResourceFieldPath. If that
+	// source resolved to multiple resources, the first one is used.
+	// +optional
+	FromExtraResource string `json:"fromExtraResource,omitempty"`
+
+	// NameTemplate is a Go text/template the name is rendered from for type
+	// Template, with the observed composite resource as its data, e.g.
+	// "{{ .spec.region }}-{{ .spec.env }}-defaults".
+	// +optional
+	NameTemplate *string `json:"nameTemplate,omitempty"`
+
+	// Candidates is an ordered list of names for type Candidates. All of
+	// them are requested at once and the first one that exists is used.
+	// Candidates whose name cannot be resolved are skipped.
+	// +optional
+	Candidates []ResourceSourceReferenceCandidate `json:"candidates,omitempty"`
+
+	// FromFieldPathPolicy specifies the policy for the nameFromFieldPath, or
+	// for the fields used by the nameTemplate.
+	// The default is Required, meaning that an error will be returned if the
+	// field is not found in the composite resource.
+	// Optional means that if the field is not found in the composite resource,
+	// the extra resource will not be requested.
+	// +kubebuilder:validation:Enum=Optional;Required
+	// +kubebuilder:default=Required
+	FromFieldPathPolicy *FromFieldPathPolicy `json:"fromFieldPathPolicy,omitempty"`
+}
+
+// GetType returns the type of the reference, returning the default if not set.
+func (e *ResourceSourceReference) GetType() ResourceSourceReferenceType {
+	if e == nil || e.Type == "" {
+		return ResourceSourceReferenceTypeName
+	}
+	return e.Type
+}
+
+// FromFieldPathIsOptional returns true if the FromFieldPathPolicy is set to Optional.
+func (e *ResourceSourceReference) FromFieldPathIsOptional() bool {
+	return e.FromFieldPathPolicy != nil && *e.FromFieldPathPolicy == FromFieldPathPolicyOptional
+}
+
+// A ResourceSourceReferenceCandidate is a candidate name of a reference.
+type ResourceSourceReferenceCandidate struct {
+	// Type specifies where the name of the object comes from.
+	// +optional
+	// +kubebuilder:validation:Enum=Name;FromCompositeFieldPath;Template
+	// +kubebuilder:default=Name
+	Type ResourceSourceReferenceType `json:"type,omitempty"`
+
+	// The name of the object.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// NameFromFieldPath specifies the field path of the composite resource to
+	// look for the name of the object.
+	// +optional
+	NameFromFieldPath *string `json:"nameFromFieldPath,omitempty"`
+
+	// NameTemplate is a Go text/template the name is rendered from for type
+	// Template, with the observed composite resource as its data.
+	// +optional
+	NameTemplate *string `json:"nameTemplate,omitempty"`
 }
 
 // An ResourceSourceSelector selects an ExtraResource via labels.
+// +kubebuilder:validation:XValidation:rule="!has(self.minMatch) || !has(self.maxMatch) || self.minMatch <= self.maxMatch",message="minMatch must not be greater than maxMatch"
 type ResourceSourceSelector struct {
 	// MaxMatch specifies the number of extracted ExtraResources in Multiple mode, extracts all if nil.
 	MaxMatch *uint64 `json:"maxMatch,omitempty"`
@@ -147,11 +558,58 @@ type ResourceSourceSelector struct {
 	MinMatch *uint64 `json:"minMatch,omitempty"`
 
 	// SortByFieldPath is the path to the field based on which list of ExtraResources is alphabetically sorted.
+	// Ignored if SortBy is set.
 	// +kubebuilder:default="metadata.name"
 	SortByFieldPath string `json:"sortByFieldPath,omitempty"`
 
+	// SortBy is an ordered list of keys the list of ExtraResources is sorted
+	// by. Later keys break ties of earlier ones, resources with equal keys keep
+	// the order they were returned in. Takes precedence over SortByFieldPath.
+	// +optional
+	SortBy []ResourceSourceSelectorSortKey `json:"sortBy,omitempty"`
+
+	// SortAs specifies how the values of all sort keys are parsed before
+	// they are compared, unless a key overrides it. If not set, values are
+	// compared as the type they have in the resource.
+	// +optional
+	// +kubebuilder:validation:Enum=String;Number;Boolean;Timestamp;SemVer;Quantity
+	SortAs SortAs `json:"sortAs,omitempty"`
+
+	// MissingKeyPolicy specifies what happens to ExtraResources missing a
+	// value for any of the sort keys. They are sorted before all others
+	// (First) or after them (Last), dropped (Exclude), or fail the function
+	// (Error).
+	// +optional
+	// +kubebuilder:validation:Enum=First;Last;Exclude;Error
+	// +kubebuilder:default=First
+	MissingKeyPolicy MissingKeyPolicy `json:"missingKeyPolicy,omitempty"`
+
 	// MatchLabels ensures an object with matching labels is selected.
 	MatchLabels []ResourceSourceSelectorLabelMatcher `json:"matchLabels,omitempty"`
+
+	// MatchAll selects all objects of the kind, regardless of their labels.
+	// MatchLabels are ignored if set.
+	// +optional
+	MatchAll bool `json:"matchAll,omitempty"`
+
+	// OnEmptySelector specifies what happens if none of the MatchLabels and
+	// MatchExpressions resolved to anything, e.g. because all of them are
+	// drawn from optional field paths that are not set. The source is either
+	// left out (SkipSource), selects all objects of the kind (MatchAll), or
+	// fails the function (Error). A skipped source matches nothing, so it
+	// still fails the function if it requires a MinMatch and is not optional.
+	// +optional
+	// +kubebuilder:validation:Enum=SkipSource;MatchAll;Error
+	// +kubebuilder:default=SkipSource
+	OnEmptySelector OnEmptySelectorPolicy `json:"onEmptySelector,omitempty"`
+
+	// MatchExpressions ensures only objects whose labels satisfy all the
+	// expressions are selected. Crossplane can only select extra resources by
+	// label equality, so the function requests the widest set of objects it
+	// can and evaluates the expressions itself before MinMatch and MaxMatch
+	// are applied.
+	// +optional
+	MatchExpressions []ResourceSourceSelectorLabelExpression `json:"matchExpressions,omitempty"`
 }
 
 // GetSortByFieldPath returns the sort by path if set or a sane default.
@@ -162,6 +620,130 @@ func (e *ResourceSourceSelector) GetSortByFieldPath() string {
 	return e.SortByFieldPath
 }
 
+// GetSortBy returns the sort keys if set, or a single ascending key on the
+// sort by path otherwise.
+func (e *ResourceSourceSelector) GetSortBy() []ResourceSourceSelectorSortKey {
+	if e != nil && len(e.SortBy) > 0 {
+		return e.SortBy
+	}
+	return []ResourceSourceSelectorSortKey{{FieldPath: e.GetSortByFieldPath()}}
+}
+
+// GetSortAs returns how the values of the given sort key are parsed, falling
+// back to the selector's SortAs.
+func (e *ResourceSourceSelector) GetSortAs(k *ResourceSourceSelectorSortKey) SortAs {
+	if k != nil && k.SortAs != "" {
+		return k.SortAs
+	}
+	if e == nil {
+		return ""
+	}
+	return e.SortAs
+}
+
+// GetOnEmptySelector returns the empty selector policy if set or a sane
+// default.
+func (e *ResourceSourceSelector) GetOnEmptySelector() OnEmptySelectorPolicy {
+	if e == nil || e.OnEmptySelector == "" {
+		return OnEmptySelectorSkipSource
+	}
+	return e.OnEmptySelector
+}
+
+// OnEmptySelectorPolicy specifies what happens to a selector without any
+// labels to match.
+type OnEmptySelectorPolicy string
+
+const (
+	// OnEmptySelectorSkipSource leaves the source out.
+	OnEmptySelectorSkipSource OnEmptySelectorPolicy = "SkipSource"
+	// OnEmptySelectorMatchAll selects all objects of the kind.
+	OnEmptySelectorMatchAll OnEmptySelectorPolicy = "MatchAll"
+	// OnEmptySelectorError fails the function.
+	OnEmptySelectorError OnEmptySelectorPolicy = "Error"
+)
+
+// GetMissingKeyPolicy returns the missing key policy if set or a sane default.
+func (e *ResourceSourceSelector) GetMissingKeyPolicy() MissingKeyPolicy {
+	if e == nil || e.MissingKeyPolicy == "" {
+		return MissingKeyPolicyFirst
+	}
+	return e.MissingKeyPolicy
+}
+
+// MissingKeyPolicy specifies what happens to resources missing a sort key.
+type MissingKeyPolicy string
+
+const (
+	// MissingKeyPolicyFirst sorts resources missing a key before all others.
+	MissingKeyPolicyFirst MissingKeyPolicy = "First"
+	// MissingKeyPolicyLast sorts resources missing a key after all others.
+	MissingKeyPolicyLast MissingKeyPolicy = "Last"
+	// MissingKeyPolicyExclude drops resources missing a key.
+	MissingKeyPolicyExclude MissingKeyPolicy = "Exclude"
+	// MissingKeyPolicyError fails the function if a resource is missing a
+	// key.
+	MissingKeyPolicyError MissingKeyPolicy = "Error"
+)
+
+// SortAs specifies how values are parsed before they are compared.
+type SortAs string
+
+const (
+	// SortAsString compares values as strings.
+	SortAsString SortAs = "String"
+	// SortAsNumber compares values as numbers, parsing strings if needed.
+	SortAsNumber SortAs = "Number"
+	// SortAsBoolean compares values as booleans, false sorting before true.
+	SortAsBoolean SortAs = "Boolean"
+	// SortAsTimestamp compares values as RFC 3339 timestamps.
+	SortAsTimestamp SortAs = "Timestamp"
+	// SortAsSemVer compares values as semantic versions, with or without a
+	// leading "v".
+	SortAsSemVer SortAs = "SemVer"
+	// SortAsQuantity compares values as Kubernetes quantities, e.g. "500Mi".
+	SortAsQuantity SortAs = "Quantity"
+)
+
+// SortDirection specifies the order resources are sorted in.
+type SortDirection string
+
+const (
+	// SortDirectionAscending sorts resources from the lowest to the highest
+	// value.
+	SortDirectionAscending SortDirection = "Ascending"
+	// SortDirectionDescending sorts resources from the highest to the lowest
+	// value.
+	SortDirectionDescending SortDirection = "Descending"
+)
+
+// ResourceSourceSelectorSortKey is a key the selected ExtraResources are
+// sorted by.
+type ResourceSourceSelectorSortKey struct {
+	// FieldPath is the path to the field the ExtraResources are sorted by.
+	FieldPath string `json:"fieldPath"`
+
+	// Direction is the order the ExtraResources are sorted in.
+	// +optional
+	// +kubebuilder:validation:Enum=Ascending;Descending
+	// +kubebuilder:default=Ascending
+	Direction SortDirection `json:"direction,omitempty"`
+
+	// SortAs overrides how the values of this key are parsed before they
+	// are compared.
+	// +optional
+	// +kubebuilder:validation:Enum=String;Number;Boolean;Timestamp;SemVer;Quantity
+	SortAs SortAs `json:"sortAs,omitempty"`
+}
+
+// GetDirection returns the sort direction if set or a sane default.
+func (k *ResourceSourceSelectorSortKey) GetDirection() SortDirection {
+	if k == nil || k.Direction == "" {
+		return SortDirectionAscending
+	}
+	return k.Direction
+}
+
 // ResourceSourceSelectorLabelMatcherType specifies where the value for a label comes from.
 type ResourceSourceSelectorLabelMatcherType string
 
@@ -172,14 +754,21 @@ const (
 	// ResourceSourceSelectorLabelMatcherTypeValue uses a literal as label
 	// value.
 	ResourceSourceSelectorLabelMatcherTypeValue ResourceSourceSelectorLabelMatcherType = "Value"
+	// ResourceSourceSelectorLabelMatcherTypeFromExtraResourceFieldPath
+	// extracts the label value from a fieldpath of the resource another
+	// source resolved to.
+	ResourceSourceSelectorLabelMatcherTypeFromExtraResourceFieldPath ResourceSourceSelectorLabelMatcherType = "FromExtraResourceFieldPath"
 )
 
 // An ResourceSourceSelectorLabelMatcher acts like a k8s label selector but
 // can draw the label value from a different path.
+// +kubebuilder:validation:XValidation:rule="!has(self.type) || self.type != 'Value' || has(self.value)",message="value is required for type Value"
+// +kubebuilder:validation:XValidation:rule="(has(self.type) && self.type == 'Value') || has(self.valueFromFieldPath)",message="valueFromFieldPath is required for types FromCompositeFieldPath and FromExtraResourceFieldPath"
+// +kubebuilder:validation:XValidation:rule="!has(self.type) || self.type != 'FromExtraResourceFieldPath' || has(self.fromExtraResource)",message="fromExtraResource is required for type FromExtraResourceFieldPath"
 type ResourceSourceSelectorLabelMatcher struct {
 	// Type specifies where the value for a label comes from.
 	// +optional
-	// +kubebuilder:validation:Enum=FromCompositeFieldPath;Value
+	// +kubebuilder:validation:Enum=FromCompositeFieldPath;Value;FromExtraResourceFieldPath
 	// +kubebuilder:default=FromCompositeFieldPath
 	Type ResourceSourceSelectorLabelMatcherType `json:"type,omitempty"`
 
@@ -189,6 +778,12 @@ type ResourceSourceSelectorLabelMatcher struct {
 	// ValueFromFieldPath specifies the field path to look for the label value.
 	ValueFromFieldPath *string `json:"valueFromFieldPath,omitempty"`
 
+	// FromExtraResource is the Into key of the source whose resolved resource
+	// the label value is drawn from for type FromExtraResourceFieldPath. If
+	// that source resolved to multiple resources, the first one is used.
+	// +optional
+	FromExtraResource string `json:"fromExtraResource,omitempty"`
+
 	// FromFieldPathPolicy specifies the policy for the valueFromFieldPath.
 	// The default is Required, meaning that an error will be returned if the
 	// field is not found in the composite resource.
@@ -202,6 +797,87 @@ type ResourceSourceSelectorLabelMatcher struct {
 
 	// Value specifies a literal label value.
 	Value *string `json:"value,omitempty"`
+
+	// FloatFormat is the fmt format floats drawn from a field path are
+	// formatted with, e.g. "%.1f". Defaults to the shortest representation,
+	// e.g. "3" or "0.5". Strings, integers and booleans are always used in
+	// their canonical form.
+	// +optional
+	FloatFormat *string `json:"floatFormat,omitempty"`
+
+	// Transforms are applied to the label value in order, e.g. to turn a
+	// field of the composite resource into a valid label value. The result
+	// must be a valid label value.
+	// +optional
+	Transforms []LabelValueTransform `json:"transforms,omitempty"`
+}
+
+// LabelValueTransformType specifies how a label value is transformed.
+type LabelValueTransformType string
+
+const (
+	// LabelValueTransformTypeLowercase lowercases the value.
+	LabelValueTransformTypeLowercase LabelValueTransformType = "Lowercase"
+	// LabelValueTransformTypeUppercase uppercases the value.
+	LabelValueTransformTypeUppercase LabelValueTransformType = "Uppercase"
+	// LabelValueTransformTypeMap looks the value up in a map.
+	LabelValueTransformTypeMap LabelValueTransformType = "Map"
+	// LabelValueTransformTypeReplace replaces all matches of a regular
+	// expression.
+	LabelValueTransformTypeReplace LabelValueTransformType = "Replace"
+	// LabelValueTransformTypePrefix prepends a string to the value.
+	LabelValueTransformTypePrefix LabelValueTransformType = "Prefix"
+	// LabelValueTransformTypeSuffix appends a string to the value.
+	LabelValueTransformTypeSuffix LabelValueTransformType = "Suffix"
+	// LabelValueTransformTypeTruncate truncates the value, appending a hash
+	// of the original value to keep it unique.
+	LabelValueTransformTypeTruncate LabelValueTransformType = "Truncate"
+)
+
+// A LabelValueTransform transforms a label value.
+type LabelValueTransform struct {
+	// Type of the transform.
+	// +kubebuilder:validation:Enum=Lowercase;Uppercase;Map;Replace;Prefix;Suffix;Truncate
+	Type LabelValueTransformType `json:"type"`
+
+	// Map of values to the values they are replaced with, for type Map.
+	// Values not in the map fail the function.
+	// +optional
+	Map map[string]string `json:"map,omitempty"`
+
+	// Replace specifies the regular expression to replace, for type Replace.
+	// +optional
+	Replace *LabelValueReplace `json:"replace,omitempty"`
+
+	// Value to prepend or append, for types Prefix and Suffix.
+	// +optional
+	Value string `json:"value,omitempty"`
+
+	// Length the value is truncated to, for type Truncate. Values longer
+	// than that are cut short and suffixed with a hash of the original value.
+	// +optional
+	// +kubebuilder:default=63
+	// +kubebuilder:validation:Minimum=10
+	// +kubebuilder:validation:Maximum=63
+	Length *int `json:"length,omitempty"`
+}
+
+// GetLength returns the length to truncate to if set or a sane default.
+func (t *LabelValueTransform) GetLength() int {
+	if t == nil || t.Length == nil {
+		return 63
+	}
+	return *t.Length
+}
+
+// A LabelValueReplace replaces all matches of a regular expression.
+type LabelValueReplace struct {
+	// Pattern is a Go regular expression.
+	Pattern string `json:"pattern"`
+
+	// Replacement for each match, which may refer to capture groups like $1.
+	// +optional
+	Replacement string `json:"replacement,omitempty"`
 }
 
 // FromFieldPathIsOptional returns true if the FromFieldPathPolicy is set to Optional.
@@ -217,6 +893,62 @@ func (e *ResourceSourceSelectorLabelMatcher) GetType() ResourceSourceSelectorLab
 	return e.Type
 }
 
+// ResourceSourceSelectorLabelExpressionOperator is a set based operator used
+// in a label expression.
+type ResourceSourceSelectorLabelExpressionOperator string
+
+const (
+	// ResourceSourceSelectorLabelExpressionOperatorIn requires the label value
+	// to be one of the supplied values.
+	ResourceSourceSelectorLabelExpressionOperatorIn ResourceSourceSelectorLabelExpressionOperator = "In"
+	// ResourceSourceSelectorLabelExpressionOperatorNotIn requires the label
+	// to be absent or its value not to be one of the supplied values.
+	ResourceSourceSelectorLabelExpressionOperatorNotIn ResourceSourceSelectorLabelExpressionOperator = "NotIn"
+	// ResourceSourceSelectorLabelExpressionOperatorExists requires the label
+	// to be present.
+	ResourceSourceSelectorLabelExpressionOperatorExists ResourceSourceSelectorLabelExpressionOperator = "Exists"
+	// ResourceSourceSelectorLabelExpressionOperatorDoesNotExist requires the
+	// label to be absent.
+	ResourceSourceSelectorLabelExpressionOperatorDoesNotExist ResourceSourceSelectorLabelExpressionOperator = "DoesNotExist"
+)
+
+// An ResourceSourceSelectorLabelExpression acts like a k8s label selector
+// requirement but can draw its values from a different path.
+type ResourceSourceSelectorLabelExpression struct {
+	// Key of the label the expression applies to.
+	Key string `json:"key"`
+
+	// Operator represents the relationship of the label to the set of values.
+	// +kubebuilder:validation:Enum=In;NotIn;Exists;DoesNotExist
+	Operator ResourceSourceSelectorLabelExpressionOperator `json:"operator"`
+
+	// Values is a list of literal label values. Only used by the In and NotIn
+	// operators.
+	// +optional
+	Values []string `json:"values,omitempty"`
+
+	// ValuesFromFieldPath specifies the field path of the composite resource
+	// to look for additional label values. The field may either be a string
+	// or a list of strings. Only used by the In and NotIn operators.
+	// +optional
+	ValuesFromFieldPath *string `json:"valuesFromFieldPath,omitempty"`
+
+	// FromFieldPathPolicy specifies the policy for the valuesFromFieldPath.
+	// The default is Required, meaning that an error will be returned if the
+	// field is not found in the composite resource.
+	// Optional means that if the field is not found in the composite resource,
+	// only the literal values will be used, and the expression will be
+	// skipped if there are none.
+	// +kubebuilder:validation:Enum=Optional;Required
+	// +kubebuilder:default=Required
+	FromFieldPathPolicy *FromFieldPathPolicy `json:"fromFieldPathPolicy,omitempty"`
+}
+
+// FromFieldPathIsOptional returns true if the FromFieldPathPolicy is set to Optional.
+func (e *ResourceSourceSelectorLabelExpression) FromFieldPathIsOptional() bool {
+	return e.FromFieldPathPolicy != nil && *e.FromFieldPathPolicy == FromFieldPathPolicyOptional
+}
+
 // A FromFieldPathPolicy determines how to patch from a field path.
 type FromFieldPathPolicy string
 
@@ -0,0 +1,341 @@
+package v1beta1
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Validate returns an error listing every problem of the input that would
+// keep its extra resources from ever being resolved, e.g. sources sharing an
+// Into key, a Reference without a ref, a Selector whose MinMatch exceeds
+// its MaxMatch or a CEL expression or regular expression that does not compile.
+func (in *Input) Validate() error {
+	return in.Spec.validate(field.NewPath("spec")).ToAggregate()
+}
+
+// validate returns the problems of the input spec.
+func (s *InputSpec) validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	into := make(map[string]bool, len(s.ExtraResources))
+	for i := range s.ExtraResources {
+		if v := s.ExtraResources[i].Into; v != "" {
+			if into[v] {
+				errs = append(errs, field.Duplicate(path.Child("extraResources").Index(i).Child("into"), v))
+			}
+			into[v] = true
+		}
+	}
+	candidates := make(map[string]int)
+	for i := range s.ExtraResources {
+		src := &s.ExtraResources[i]
+		if src.GetType() != ResourceSourceTypeReference || src.Ref.GetType() != ResourceSourceReferenceTypeCandidates {
+			continue
+		}
+		for j := range src.Ref.Candidates {
+			candidates[src.GetCandidateKey(j)] = i
+		}
+	}
+	for i := range s.ExtraResources {
+		src := &s.ExtraResources[i]
+		if j, ok := candidates[src.Into]; ok {
+			errs = append(errs, field.Invalid(path.Child("extraResources").Index(i).Child("into"), src.Into, fmt.Sprintf("into must not be the key a candidate of extraResources[%d] is requested under", j)))
+		}
+		errs = append(errs, src.validate(path.Child("extraResources").Index(i), into)...)
+	}
+	errs = append(errs, s.validateCycles(path.Child("extraResources"))...)
+	return errs
+}
+
+// validateCycles returns a problem for every source that closes a cycle of
+// sources drawing values from each other. Dependencies on unknown sources or on
+// the source itself are reported by validateDependency.
+func (s *InputSpec) validateCycles(path *field.Path) field.ErrorList {
+	index := make(map[string]int, len(s.ExtraResources))
+	for i := range s.ExtraResources {
+		if _, ok := index[s.ExtraResources[i].Into]; !ok {
+			index[s.ExtraResources[i].Into] = i
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	var errs field.ErrorList
+	state := make(map[string]int, len(s.ExtraResources))
+	var visit func(into string, chain []string)
+	visit = func(into string, chain []string) {
+		state[into] = visiting
+		chain = append(chain, into)
+		for _, d := range s.ExtraResources[index[into]].GetDependencies() {
+			if _, ok := index[d]; !ok || d == into {
+				continue
+			}
+			switch state[d] {
+			case unvisited:
+				visit(d, chain)
+			case visiting:
+				cycle := append(slices.Clone(chain[slices.Index(chain, d):]), d)
+				errs = append(errs, field.Invalid(path.Index(index[into]).Child("into"), into, "extra resources depend on each other in a cycle: "+strings.Join(cycle, " -> ")))
+			}
+		}
+		state[into] = visited
+	}
+	for i := range s.ExtraResources {
+		if into := s.ExtraResources[i].Into; state[into] == unvisited && index[into] == i {
+			visit(into, nil)
+		}
+	}
+	return errs
+}
+
+// validate returns the problems of the source. Into holds the Into keys of
+// all sources of the input.
+func (e *ResourceSource) validate(path *field.Path, into map[string]bool) field.ErrorList {
+	var errs field.ErrorList
+	if e.Into == "" {
+		errs = append(errs, field.Required(path.Child("into"), "into is required"))
+	}
+	if e.Ref != nil && e.Ref.GetType() == ResourceSourceReferenceTypeFromExtraResourceFieldPath {
+		errs = append(errs, validateDependency(path.Child("ref", "fromExtraResource"), e.Ref.FromExtraResource, e.Into, into)...)
+	}
+	if e.Selector != nil {
+		for i := range e.Selector.MatchLabels {
+			m := &e.Selector.MatchLabels[i]
+			if m.GetType() == ResourceSourceSelectorLabelMatcherTypeFromExtraResourceFieldPath {
+				errs = append(errs, validateDependency(path.Child("selector", "matchLabels").Index(i).Child("fromExtraResource"), m.FromExtraResource, e.Into, into)...)
+			}
+		}
+	}
+	switch e.GetType() {
+	case ResourceSourceTypeReference:
+		if e.Ref == nil {
+			errs = append(errs, field.Required(path.Child("ref"), "ref is required for type Reference"))
+			break
+		}
+		errs = append(errs, e.Ref.validate(path.Child("ref"))...)
+	case ResourceSourceTypeSelector:
+		if e.Selector == nil {
+			errs = append(errs, field.Required(path.Child("selector"), "selector is required for type Selector"))
+			break
+		}
+		errs = append(errs, e.Selector.validate(path.Child("selector"))...)
+	default:
+		errs = append(errs, field.NotSupported(path.Child("type"), e.Type, []ResourceSourceType{
+			ResourceSourceTypeReference,
+			ResourceSourceTypeSelector,
+		}))
+	}
+	if e.Filter != nil {
+		if _, err := CompileCELExpression(*e.Filter); err != nil {
+			errs = append(errs, field.Invalid(path.Child("filter"), *e.Filter, err.Error()))
+		}
+	}
+	if e.NamespaceFrom != nil {
+		errs = append(errs, e.NamespaceFrom.validate(path.Child("namespaceFrom"))...)
+	}
+	if e.When != nil {
+		errs = append(errs, e.When.validate(path.Child("when"))...)
+	}
+	return errs
+}
+
+// Validate that a source draws values from the extra resources of another
+// source of the input. Missing keys are reported by the reference or label
+// matcher.
+func validateDependency(path *field.Path, dep, self string, into map[string]bool) field.ErrorList {
+	switch {
+	case dep == "":
+		return nil
+	case dep == self:
+		return field.ErrorList{field.Invalid(path, dep, "a source cannot draw values from its own extra resources")}
+	case !into[dep]:
+		return field.ErrorList{field.NotFound(path, dep)}
+	}
+	return nil
+}
+
+// validate returns the problems of the reference.
+func (e *ResourceSourceReference) validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	switch t := e.GetType(); t {
+	case ResourceSourceReferenceTypeName:
+		if e.Name == "" {
+			errs = append(errs, field.Required(path.Child("name"), "name is required for type Name"))
+		}
+	case ResourceSourceReferenceTypeFromCompositeFieldPath:
+		if e.NameFromFieldPath == nil {
+			errs = append(errs, field.Required(path.Child("nameFromFieldPath"), "nameFromFieldPath is required for type FromCompositeFieldPath"))
+		}
+	case ResourceSourceReferenceTypeFromExtraResourceFieldPath:
+		if e.NameFromFieldPath == nil {
+			errs = append(errs, field.Required(path.Child("nameFromFieldPath"), "nameFromFieldPath is required for type FromExtraResourceFieldPath"))
+		}
+		if e.FromExtraResource == "" {
+			errs = append(errs, field.Required(path.Child("fromExtraResource"), "fromExtraResource is required for type FromExtraResourceFieldPath"))
+		}
+	case ResourceSourceReferenceTypeTemplate:
+		if e.NameTemplate == nil {
+			errs = append(errs, field.Required(path.Child("nameTemplate"), "nameTemplate is required for type Template"))
+		}
+	case ResourceSourceReferenceTypeCandidates:
+		if len(e.Candidates) == 0 {
+			errs = append(errs, field.Required(path.Child("candidates"), "candidates are required for type Candidates"))
+		}
+		for i := range e.Candidates {
+			errs = append(errs, e.Candidates[i].validate(path.Child("candidates").Index(i))...)
+		}
+	default:
+		errs = append(errs, field.NotSupported(path.Child("type"), t, []ResourceSourceReferenceType{
+			ResourceSourceReferenceTypeName,
+			ResourceSourceReferenceTypeFromCompositeFieldPath,
+			ResourceSourceReferenceTypeFromExtraResourceFieldPath,
+			ResourceSourceReferenceTypeTemplate,
+			ResourceSourceReferenceTypeCandidates,
+		}))
+	}
+	return errs
+}
+
+// validate returns the problems of the candidate.
+func (c *ResourceSourceReferenceCandidate) validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	switch c.Type {
+	case ResourceSourceReferenceTypeName, "":
+		if c.Name == "" {
+			errs = append(errs, field.Required(path.Child("name"), "name is required for type Name"))
+		}
+	case ResourceSourceReferenceTypeFromCompositeFieldPath:
+		if c.NameFromFieldPath == nil {
+			errs = append(errs, field.Required(path.Child("nameFromFieldPath"), "nameFromFieldPath is required for type FromCompositeFieldPath"))
+		}
+	case ResourceSourceReferenceTypeTemplate:
+		if c.NameTemplate == nil {
+			errs = append(errs, field.Required(path.Child("nameTemplate"), "nameTemplate is required for type Template"))
+		}
+	default:
+		errs = append(errs, field.NotSupported(path.Child("type"), c.Type, []ResourceSourceReferenceType{
+			ResourceSourceReferenceTypeName,
+			ResourceSourceReferenceTypeFromCompositeFieldPath,
+			ResourceSourceReferenceTypeTemplate,
+		}))
+	}
+	return errs
+}
+
+// validate returns the problems of the selector.
+func (e *ResourceSourceSelector) validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if e.MinMatch != nil && e.MaxMatch != nil && *e.MinMatch > *e.MaxMatch {
+		errs = append(errs, field.Invalid(path.Child("minMatch"), *e.MinMatch, "minMatch must not be greater than maxMatch"))
+	}
+	for i := range e.MatchLabels {
+		errs = append(errs, e.MatchLabels[i].validate(path.Child("matchLabels").Index(i))...)
+	}
+	return errs
+}
+
+// validate returns the problems of the label matcher.
+func (e *ResourceSourceSelectorLabelMatcher) validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if e.Key == "" {
+		errs = append(errs, field.Required(path.Child("key"), "key is required"))
+	}
+	switch t := e.GetType(); t {
+	case ResourceSourceSelectorLabelMatcherTypeValue:
+		if e.Value == nil {
+			errs = append(errs, field.Required(path.Child("value"), "value is required for type Value"))
+		}
+	case ResourceSourceSelectorLabelMatcherTypeFromCompositeFieldPath:
+		if e.ValueFromFieldPath == nil {
+			errs = append(errs, field.Required(path.Child("valueFromFieldPath"), "valueFromFieldPath is required for type FromCompositeFieldPath"))
+		}
+	case ResourceSourceSelectorLabelMatcherTypeFromExtraResourceFieldPath:
+		if e.ValueFromFieldPath == nil {
+			errs = append(errs, field.Required(path.Child("valueFromFieldPath"), "valueFromFieldPath is required for type FromExtraResourceFieldPath"))
+		}
+		if e.FromExtraResource == "" {
+			errs = append(errs, field.Required(path.Child("fromExtraResource"), "fromExtraResource is required for type FromExtraResourceFieldPath"))
+		}
+	default:
+		errs = append(errs, field.NotSupported(path.Child("type"), t, []ResourceSourceSelectorLabelMatcherType{
+			ResourceSourceSelectorLabelMatcherTypeFromCompositeFieldPath,
+			ResourceSourceSelectorLabelMatcherTypeValue,
+			ResourceSourceSelectorLabelMatcherTypeFromExtraResourceFieldPath,
+		}))
+	}
+	for i := range e.Transforms {
+		errs = append(errs, e.Transforms[i].validate(path.Child("transforms").Index(i))...)
+	}
+	return errs
+}
+
+// validate returns the problems of the transform.
+func (t *LabelValueTransform) validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	switch t.Type { //nolint:exhaustive // The other types have no required fields.
+	case LabelValueTransformTypeMap:
+		if len(t.Map) == 0 {
+			errs = append(errs, field.Required(path.Child("map"), "map is required for type Map"))
+		}
+	case LabelValueTransformTypeReplace:
+		if t.Replace == nil {
+			errs = append(errs, field.Required(path.Child("replace"), "replace is required for type Replace"))
+			break
+		}
+		if _, err := regexp.Compile(t.Replace.Pattern); err != nil {
+			errs = append(errs, field.Invalid(path.Child("replace", "pattern"), t.Replace.Pattern, err.Error()))
+		}
+	}
+	return errs
+}
+
+// validate returns the problems of the namespace.
+func (e *ResourceSourceNamespace) validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	switch e.GetType() { //nolint:exhaustive // XRNamespace has no required fields.
+	case ResourceSourceNamespaceTypeValue:
+		if e.Value == nil {
+			errs = append(errs, field.Required(path.Child("value"), "value is required for type Value"))
+		}
+	case ResourceSourceNamespaceTypeFromCompositeFieldPath:
+		if e.ValueFromFieldPath == nil {
+			errs = append(errs, field.Required(path.Child("valueFromFieldPath"), "valueFromFieldPath is required for type FromCompositeFieldPath"))
+		}
+	}
+	return errs
+}
+
+// validate returns the problems of the condition.
+func (c *ResourceSourceCondition) validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	switch c.Type {
+	case ResourceSourceConditionTypeFieldPathExists, ResourceSourceConditionTypeFieldPathEquals:
+		if c.FieldPath == nil {
+			errs = append(errs, field.Required(path.Child("fieldPath"), "fieldPath is required for type "+string(c.Type)))
+		}
+		if c.Type == ResourceSourceConditionTypeFieldPathEquals && c.Value == nil {
+			errs = append(errs, field.Required(path.Child("value"), "value is required for type FieldPathEquals"))
+		}
+	case ResourceSourceConditionTypeExpression:
+		if c.Expression == nil {
+			errs = append(errs, field.Required(path.Child("expression"), "expression is required for type Expression"))
+			break
+		}
+		if _, err := CompileCELExpression(*c.Expression); err != nil {
+			errs = append(errs, field.Invalid(path.Child("expression"), *c.Expression, err.Error()))
+		}
+	default:
+		errs = append(errs, field.NotSupported(path.Child("type"), c.Type, []ResourceSourceConditionType{
+			ResourceSourceConditionTypeFieldPathExists,
+			ResourceSourceConditionTypeFieldPathEquals,
+			ResourceSourceConditionTypeExpression,
+		}))
+	}
+	return errs
+}
@@ -0,0 +1,231 @@
+package v1beta1
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/ptr"
+)
+
+func TestValidate(t *testing.T) {
+	ref := &ResourceSourceReference{Name: "my-env-config"}
+	_, filterErr := CompileCELExpression("object.data.enabled &&")
+	_, whenErr := CompileCELExpression("xr.spec.replicas >")
+	_, patternErr := regexp.Compile("[a-z")
+
+	cases := map[string]struct {
+		reason string
+		spec   InputSpec
+		want   field.ErrorList
+	}{
+		"Valid": {
+			reason: "A valid input should have no problems",
+			spec: InputSpec{
+				ExtraResources: []ResourceSource{
+					{Into: "obj-0", Ref: ref},
+					{
+						Type: ResourceSourceTypeSelector,
+						Into: "obj-1",
+						Selector: &ResourceSourceSelector{
+							MinMatch: ptr.To[uint64](1),
+							MaxMatch: ptr.To[uint64](1),
+							MatchLabels: []ResourceSourceSelectorLabelMatcher{{
+								Type:               ResourceSourceSelectorLabelMatcherTypeFromExtraResourceFieldPath,
+								Key:                "team",
+								ValueFromFieldPath: ptr.To("metadata.labels.team"),
+								FromExtraResource:  "obj-0",
+							}},
+						},
+					},
+				},
+			},
+		},
+		"DuplicateInto": {
+			reason: "Sources sharing an into key should be reported",
+			spec: InputSpec{
+				ExtraResources: []ResourceSource{
+					{Into: "obj-0", Ref: ref},
+					{Into: "obj-0", Ref: ref},
+				},
+			},
+			want: field.ErrorList{
+				field.Duplicate(field.NewPath("spec", "extraResources").Index(1).Child("into"), "obj-0"),
+			},
+		},
+		"IntoCollidesWithCandidateKey": {
+			reason: "Sources whose into key is the key a candidate of another source is requested under should be reported",
+			spec: InputSpec{
+				ExtraResources: []ResourceSource{
+					{
+						Into: "cfg",
+						Ref: &ResourceSourceReference{
+							Type:       ResourceSourceReferenceTypeCandidates,
+							Candidates: []ResourceSourceReferenceCandidate{{Name: "a"}, {Name: "b"}},
+						},
+					},
+					{Into: "cfg[1]", Ref: ref},
+				},
+			},
+			want: field.ErrorList{
+				field.Invalid(field.NewPath("spec", "extraResources").Index(1).Child("into"), "cfg[1]", "into must not be the key a candidate of extraResources[0] is requested under"),
+			},
+		},
+		"MissingRefAndSelector": {
+			reason: "References without a ref and selectors without a selector should be reported",
+			spec: InputSpec{
+				ExtraResources: []ResourceSource{
+					{Into: "obj-0"},
+					{Into: "obj-1", Type: ResourceSourceTypeSelector},
+				},
+			},
+			want: field.ErrorList{
+				field.Required(field.NewPath("spec", "extraResources").Index(0).Child("ref"), "ref is required for type Reference"),
+				field.Required(field.NewPath("spec", "extraResources").Index(1).Child("selector"), "selector is required for type Selector"),
+			},
+		},
+		"MinMatchGreaterThanMaxMatch": {
+			reason: "Selectors whose minMatch exceeds their maxMatch should be reported",
+			spec: InputSpec{
+				ExtraResources: []ResourceSource{
+					{
+						Type: ResourceSourceTypeSelector,
+						Into: "obj-0",
+						Selector: &ResourceSourceSelector{
+							MinMatch: ptr.To[uint64](2),
+							MaxMatch: ptr.To[uint64](1),
+						},
+					},
+				},
+			},
+			want: field.ErrorList{
+				field.Invalid(field.NewPath("spec", "extraResources").Index(0).Child("selector", "minMatch"), uint64(2), "minMatch must not be greater than maxMatch"),
+			},
+		},
+		"UnknownDependency": {
+			reason: "Sources drawing values from unknown or their own extra resources should be reported",
+			spec: InputSpec{
+				ExtraResources: []ResourceSource{
+					{
+						Into: "obj-0",
+						Ref: &ResourceSourceReference{
+							Type:              ResourceSourceReferenceTypeFromExtraResourceFieldPath,
+							NameFromFieldPath: ptr.To("data.name"),
+							FromExtraResource: "obj-9",
+						},
+					},
+					{
+						Into: "obj-1",
+						Ref: &ResourceSourceReference{
+							Type:              ResourceSourceReferenceTypeFromExtraResourceFieldPath,
+							NameFromFieldPath: ptr.To("data.name"),
+							FromExtraResource: "obj-1",
+						},
+					},
+				},
+			},
+			want: field.ErrorList{
+				field.NotFound(field.NewPath("spec", "extraResources").Index(0).Child("ref", "fromExtraResource"), "obj-9"),
+				field.Invalid(field.NewPath("spec", "extraResources").Index(1).Child("ref", "fromExtraResource"), "obj-1", "a source cannot draw values from its own extra resources"),
+			},
+		},
+		"DependencyCycle": {
+			reason: "Sources drawing values from each other in a cycle should be reported once",
+			spec: InputSpec{
+				ExtraResources: []ResourceSource{
+					{
+						Into: "obj-0",
+						Ref: &ResourceSourceReference{
+							Type:              ResourceSourceReferenceTypeFromExtraResourceFieldPath,
+							NameFromFieldPath: ptr.To("data.name"),
+							FromExtraResource: "obj-1",
+						},
+					},
+					{
+						Type: ResourceSourceTypeSelector,
+						Into: "obj-1",
+						Selector: &ResourceSourceSelector{
+							MatchLabels: []ResourceSourceSelectorLabelMatcher{{
+								Type:               ResourceSourceSelectorLabelMatcherTypeFromExtraResourceFieldPath,
+								Key:                "team",
+								ValueFromFieldPath: ptr.To("metadata.labels.team"),
+								FromExtraResource:  "obj-0",
+							}},
+						},
+					},
+				},
+			},
+			want: field.ErrorList{
+				field.Invalid(field.NewPath("spec", "extraResources").Index(1).Child("into"), "obj-1", "extra resources depend on each other in a cycle: obj-0 -> obj-1 -> obj-0"),
+			},
+		},
+		"InvalidExpressions": {
+			reason: "CEL expressions and regular expressions that do not compile should be reported",
+			spec: InputSpec{
+				ExtraResources: []ResourceSource{
+					{
+						Into:   "obj-0",
+						Ref:    ref,
+						Filter: ptr.To("object.data.enabled &&"),
+						When: &ResourceSourceCondition{
+							Type:       ResourceSourceConditionTypeExpression,
+							Expression: ptr.To("xr.spec.replicas >"),
+						},
+					},
+					{
+						Type: ResourceSourceTypeSelector,
+						Into: "obj-1",
+						Selector: &ResourceSourceSelector{
+							MatchLabels: []ResourceSourceSelectorLabelMatcher{{
+								Type:  ResourceSourceSelectorLabelMatcherTypeValue,
+								Key:   "team",
+								Value: ptr.To("platform"),
+								Transforms: []LabelValueTransform{{
+									Type:    LabelValueTransformTypeReplace,
+									Replace: &LabelValueReplace{Pattern: "[a-z"},
+								}},
+							}},
+						},
+					},
+				},
+			},
+			want: field.ErrorList{
+				field.Invalid(field.NewPath("spec", "extraResources").Index(0).Child("filter"), "object.data.enabled &&", filterErr.Error()),
+				field.Invalid(field.NewPath("spec", "extraResources").Index(0).Child("when", "expression"), "xr.spec.replicas >", whenErr.Error()),
+				field.Invalid(field.NewPath("spec", "extraResources").Index(1).Child("selector", "matchLabels").Index(0).Child("transforms").Index(0).Child("replace", "pattern"), "[a-z", patternErr.Error()),
+			},
+		},
+		"MissingTypeFields": {
+			reason: "Fields required by the type of references and label matchers should be reported",
+			spec: InputSpec{
+				ExtraResources: []ResourceSource{
+					{Into: "obj-0", Ref: &ResourceSourceReference{Type: ResourceSourceReferenceTypeTemplate}},
+					{
+						Type: ResourceSourceTypeSelector,
+						Into: "obj-1",
+						Selector: &ResourceSourceSelector{
+							MatchLabels: []ResourceSourceSelectorLabelMatcher{{
+								Type: ResourceSourceSelectorLabelMatcherTypeValue,
+								Key:  "team",
+							}},
+						},
+					},
+				},
+			},
+			want: field.ErrorList{
+				field.Required(field.NewPath("spec", "extraResources").Index(0).Child("ref", "nameTemplate"), "nameTemplate is required for type Template"),
+				field.Required(field.NewPath("spec", "extraResources").Index(1).Child("selector", "matchLabels").Index(0).Child("value"), "value is required for type Value"),
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := tc.spec.validate(field.NewPath("spec"))
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s\nvalidate(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -70,6 +70,11 @@ func (in *InputSpec) DeepCopyInto(out *InputSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Merge != nil {
+		in, out := &in.Merge, &out.Merge
+		*out = new(Merge)
+		**out = **in
+	}
 	if in.Policy != nil {
 		in, out := &in.Policy, &out.Policy
 		*out = new(Policy)
@@ -87,6 +92,68 @@ func (in *InputSpec) DeepCopy() *InputSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabelValueReplace) DeepCopyInto(out *LabelValueReplace) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabelValueReplace.
+func (in *LabelValueReplace) DeepCopy() *LabelValueReplace {
+	if in == nil {
+		return nil
+	}
+	out := new(LabelValueReplace)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabelValueTransform) DeepCopyInto(out *LabelValueTransform) {
+	*out = *in
+	if in.Map != nil {
+		in, out := &in.Map, &out.Map
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Replace != nil {
+		in, out := &in.Replace, &out.Replace
+		*out = new(LabelValueReplace)
+		**out = **in
+	}
+	if in.Length != nil {
+		in, out := &in.Length, &out.Length
+		*out = new(int)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabelValueTransform.
+func (in *LabelValueTransform) DeepCopy() *LabelValueTransform {
+	if in == nil {
+		return nil
+	}
+	out := new(LabelValueTransform)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Merge) DeepCopyInto(out *Merge) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Merge.
+func (in *Merge) DeepCopy() *Merge {
+	if in == nil {
+		return nil
+	}
+	out := new(Merge)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Policy) DeepCopyInto(out *Policy) {
 	*out = *in
@@ -113,7 +180,7 @@ func (in *ResourceSource) DeepCopyInto(out *ResourceSource) {
 	if in.Ref != nil {
 		in, out := &in.Ref, &out.Ref
 		*out = new(ResourceSourceReference)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	if in.Selector != nil {
 		in, out := &in.Selector, &out.Selector
@@ -125,6 +192,41 @@ func (in *ResourceSource) DeepCopyInto(out *ResourceSource) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.NamespaceFrom != nil {
+		in, out := &in.NamespaceFrom, &out.NamespaceFrom
+		*out = new(ResourceSourceNamespace)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Fields != nil {
+		in, out := &in.Fields, &out.Fields
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.StripManagedFields != nil {
+		in, out := &in.StripManagedFields, &out.StripManagedFields
+		*out = new(bool)
+		**out = **in
+	}
+	if in.ToCompositeFieldPaths != nil {
+		in, out := &in.ToCompositeFieldPaths, &out.ToCompositeFieldPaths
+		*out = make([]ResourceSourceToCompositeFieldPath, len(*in))
+		copy(*out, *in)
+	}
+	if in.Filter != nil {
+		in, out := &in.Filter, &out.Filter
+		*out = new(string)
+		**out = **in
+	}
+	if in.When != nil {
+		in, out := &in.When, &out.When
+		*out = new(ResourceSourceCondition)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Policy != nil {
+		in, out := &in.Policy, &out.Policy
+		*out = new(Policy)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceSource.
@@ -137,9 +239,86 @@ func (in *ResourceSource) DeepCopy() *ResourceSource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResourceSourceCondition) DeepCopyInto(out *ResourceSourceCondition) {
+	*out = *in
+	if in.FieldPath != nil {
+		in, out := &in.FieldPath, &out.FieldPath
+		*out = new(string)
+		**out = **in
+	}
+	if in.Value != nil {
+		in, out := &in.Value, &out.Value
+		*out = new(string)
+		**out = **in
+	}
+	if in.Expression != nil {
+		in, out := &in.Expression, &out.Expression
+		*out = new(string)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceSourceCondition.
+func (in *ResourceSourceCondition) DeepCopy() *ResourceSourceCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourceSourceCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResourceSourceNamespace) DeepCopyInto(out *ResourceSourceNamespace) {
+	*out = *in
+	if in.Value != nil {
+		in, out := &in.Value, &out.Value
+		*out = new(string)
+		**out = **in
+	}
+	if in.ValueFromFieldPath != nil {
+		in, out := &in.ValueFromFieldPath, &out.ValueFromFieldPath
+		*out = new(string)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceSourceNamespace.
+func (in *ResourceSourceNamespace) DeepCopy() *ResourceSourceNamespace {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourceSourceNamespace)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ResourceSourceReference) DeepCopyInto(out *ResourceSourceReference) {
 	*out = *in
+	if in.NameFromFieldPath != nil {
+		in, out := &in.NameFromFieldPath, &out.NameFromFieldPath
+		*out = new(string)
+		**out = **in
+	}
+	if in.NameTemplate != nil {
+		in, out := &in.NameTemplate, &out.NameTemplate
+		*out = new(string)
+		**out = **in
+	}
+	if in.Candidates != nil {
+		in, out := &in.Candidates, &out.Candidates
+		*out = make([]ResourceSourceReferenceCandidate, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.FromFieldPathPolicy != nil {
+		in, out := &in.FromFieldPathPolicy, &out.FromFieldPathPolicy
+		*out = new(FromFieldPathPolicy)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceSourceReference.
@@ -152,6 +331,31 @@ func (in *ResourceSourceReference) DeepCopy() *ResourceSourceReference {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResourceSourceReferenceCandidate) DeepCopyInto(out *ResourceSourceReferenceCandidate) {
+	*out = *in
+	if in.NameFromFieldPath != nil {
+		in, out := &in.NameFromFieldPath, &out.NameFromFieldPath
+		*out = new(string)
+		**out = **in
+	}
+	if in.NameTemplate != nil {
+		in, out := &in.NameTemplate, &out.NameTemplate
+		*out = new(string)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceSourceReferenceCandidate.
+func (in *ResourceSourceReferenceCandidate) DeepCopy() *ResourceSourceReferenceCandidate {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourceSourceReferenceCandidate)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ResourceSourceSelector) DeepCopyInto(out *ResourceSourceSelector) {
 	*out = *in
@@ -165,6 +369,11 @@ func (in *ResourceSourceSelector) DeepCopyInto(out *ResourceSourceSelector) {
 		*out = new(uint64)
 		**out = **in
 	}
+	if in.SortBy != nil {
+		in, out := &in.SortBy, &out.SortBy
+		*out = make([]ResourceSourceSelectorSortKey, len(*in))
+		copy(*out, *in)
+	}
 	if in.MatchLabels != nil {
 		in, out := &in.MatchLabels, &out.MatchLabels
 		*out = make([]ResourceSourceSelectorLabelMatcher, len(*in))
@@ -172,6 +381,13 @@ func (in *ResourceSourceSelector) DeepCopyInto(out *ResourceSourceSelector) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.MatchExpressions != nil {
+		in, out := &in.MatchExpressions, &out.MatchExpressions
+		*out = make([]ResourceSourceSelectorLabelExpression, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceSourceSelector.
@@ -184,6 +400,36 @@ func (in *ResourceSourceSelector) DeepCopy() *ResourceSourceSelector {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResourceSourceSelectorLabelExpression) DeepCopyInto(out *ResourceSourceSelectorLabelExpression) {
+	*out = *in
+	if in.Values != nil {
+		in, out := &in.Values, &out.Values
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ValuesFromFieldPath != nil {
+		in, out := &in.ValuesFromFieldPath, &out.ValuesFromFieldPath
+		*out = new(string)
+		**out = **in
+	}
+	if in.FromFieldPathPolicy != nil {
+		in, out := &in.FromFieldPathPolicy, &out.FromFieldPathPolicy
+		*out = new(FromFieldPathPolicy)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceSourceSelectorLabelExpression.
+func (in *ResourceSourceSelectorLabelExpression) DeepCopy() *ResourceSourceSelectorLabelExpression {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourceSourceSelectorLabelExpression)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ResourceSourceSelectorLabelMatcher) DeepCopyInto(out *ResourceSourceSelectorLabelMatcher) {
 	*out = *in
@@ -202,6 +448,18 @@ func (in *ResourceSourceSelectorLabelMatcher) DeepCopyInto(out *ResourceSourceSe
 		*out = new(string)
 		**out = **in
 	}
+	if in.FloatFormat != nil {
+		in, out := &in.FloatFormat, &out.FloatFormat
+		*out = new(string)
+		**out = **in
+	}
+	if in.Transforms != nil {
+		in, out := &in.Transforms, &out.Transforms
+		*out = make([]LabelValueTransform, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceSourceSelectorLabelMatcher.
@@ -213,3 +471,33 @@ func (in *ResourceSourceSelectorLabelMatcher) DeepCopy() *ResourceSourceSelector
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResourceSourceSelectorSortKey) DeepCopyInto(out *ResourceSourceSelectorSortKey) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceSourceSelectorSortKey.
+func (in *ResourceSourceSelectorSortKey) DeepCopy() *ResourceSourceSelectorSortKey {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourceSourceSelectorSortKey)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResourceSourceToCompositeFieldPath) DeepCopyInto(out *ResourceSourceToCompositeFieldPath) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceSourceToCompositeFieldPath.
+func (in *ResourceSourceToCompositeFieldPath) DeepCopy() *ResourceSourceToCompositeFieldPath {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourceSourceToCompositeFieldPath)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -9,6 +9,13 @@ import (
 
 // CLI of this Function.
 type CLI struct {
+	Serve    ServeCmd    `cmd:"" default:"withargs" help:"Serve the Function. This is the default command."`
+	Validate ValidateCmd `cmd:"" help:"Validate Function inputs offline."`
+	Resolve  ResolveCmd  `cmd:"" help:"Resolve the extra resources of a Function input offline."`
+}
+
+// ServeCmd serves the Function.
+type ServeCmd struct {
 	Debug bool `help:"Emit debug logs in addition to info logs." short:"d"`
 
 	Network            string `default:"tcp"                                                                                        help:"Network on which to listen for gRPC connections."`
@@ -19,7 +26,7 @@ type CLI struct {
 }
 
 // Run this Function.
-func (c *CLI) Run() error {
+func (c *ServeCmd) Run() error {
 	log, err := function.NewLogger(c.Debug)
 	if err != nil {
 		return err
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path/filepath"
+
+	"github.com/alecthomas/kong"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"google.golang.org/protobuf/types/known/structpb"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+
+	function "github.com/crossplane/function-sdk-go"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+// maxRequirementsIterations is the number of times Crossplane calls a Function
+// with the resources it required before giving up, if its requirements keep
+// changing.
+const maxRequirementsIterations = 5
+
+// ResolveCmd resolves the extra resources of an input offline.
+type ResolveCmd struct {
+	Debug bool `help:"Emit debug logs in addition to info logs." short:"d"`
+
+	Input     string   `arg:"" help:"YAML file containing the input of this Function, or a Composition whose pipeline uses it." type:"existingfile"`
+	Composite string   `arg:"" help:"YAML file containing the observed composite resource."                                      type:"existingfile"`
+	Resources []string `arg:"" help:"YAML files, or directories of them, containing the resources that may be required."         optional:""       type:"path"`
+}
+
+// Run simulates the requirements loop of Crossplane against the resources and
+// prints the resulting context as JSON.
+func (c *ResolveCmd) Run(k *kong.Context) error {
+	log, err := function.NewLogger(c.Debug)
+	if err != nil {
+		return err
+	}
+
+	inputs, err := readInputs(c.Input)
+	if err != nil {
+		return errors.Wrapf(err, "cannot read input from %q", c.Input)
+	}
+	if len(inputs) != 1 {
+		return errors.Errorf("expected exactly one input in %q, found %d", c.Input, len(inputs))
+	}
+	in, err := resource.AsStruct(inputs[0].Input)
+	if err != nil {
+		return errors.Wrap(err, "cannot convert input")
+	}
+
+	docs, err := readDocuments(c.Composite)
+	if err != nil {
+		return errors.Wrapf(err, "cannot read composite resource from %q", c.Composite)
+	}
+	if len(docs) != 1 {
+		return errors.Errorf("expected exactly one composite resource in %q, found %d", c.Composite, len(docs))
+	}
+	xr, err := structpb.NewStruct(docs[0])
+	if err != nil {
+		return errors.Wrap(err, "cannot convert composite resource")
+	}
+
+	candidates, err := readCandidates(c.Resources)
+	if err != nil {
+		return err
+	}
+
+	req := &fnv1.RunFunctionRequest{
+		Meta:     &fnv1.RequestMeta{Tag: "resolve"},
+		Observed: &fnv1.State{Composite: &fnv1.Resource{Resource: xr}},
+		Desired:  &fnv1.State{},
+		Input:    in,
+	}
+	rsp, err := simulate(context.Background(), &Function{log: log}, req, candidates)
+	for _, r := range rsp.GetResults() {
+		fmt.Fprintf(k.Stderr, "%s: %s\n", severityName(r.GetSeverity()), r.GetMessage())
+	}
+	if err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(rsp.GetContext().AsMap(), "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "cannot marshal context")
+	}
+	fmt.Fprintln(k.Stdout, string(out))
+	return nil
+}
+
+// Run the Function the way Crossplane does, supplying the resources it
+// requires from the candidates until its requirements stop changing.
+func simulate(ctx context.Context, f *Function, req *fnv1.RunFunctionRequest, candidates []unstructured.Unstructured) (*fnv1.RunFunctionResponse, error) {
+	var requirements *fnv1.Requirements
+	for i := 0; i <= maxRequirementsIterations; i++ {
+		rsp, err := f.RunFunction(ctx, req)
+		if err != nil {
+			return rsp, err
+		}
+		for _, r := range rsp.GetResults() {
+			if r.GetSeverity() == fnv1.Severity_SEVERITY_FATAL {
+				return rsp, errors.Errorf("function returned a fatal result: %s", r.GetMessage())
+			}
+		}
+		rs := rsp.GetRequirements()
+		if rs == nil || (requirements != nil && requirementsEqual(rs, requirements)) {
+			return rsp, nil
+		}
+		requirements = rs
+		req.RequiredResources, err = requiredResources(rs, candidates)
+		if err != nil {
+			return rsp, err
+		}
+		// Crossplane sends an empty map of required resources as nothing at
+		// all over the wire.
+		if len(req.RequiredResources) == 0 {
+			req.RequiredResources = nil
+		}
+	}
+	return nil, errors.Errorf("requirements did not stabilize after %d iterations", maxRequirementsIterations)
+}
+
+// Returns true if both requirements select the same resources.
+func requirementsEqual(a, b *fnv1.Requirements) bool {
+	return maps.EqualFunc(a.GetResources(), b.GetResources(), func(x, y *fnv1.ResourceSelector) bool {
+		return x.GetApiVersion() == y.GetApiVersion() &&
+			x.GetKind() == y.GetKind() &&
+			x.GetNamespace() == y.GetNamespace() &&
+			x.GetMatchName() == y.GetMatchName() &&
+			maps.Equal(x.GetMatchLabels().GetLabels(), y.GetMatchLabels().GetLabels())
+	})
+}
+
+// Return the candidates each selector of the requirements matches.
+func requiredResources(rs *fnv1.Requirements, candidates []unstructured.Unstructured) (map[string]*fnv1.Resources, error) {
+	required := make(map[string]*fnv1.Resources, len(rs.GetResources()))
+	for key, sel := range rs.GetResources() {
+		items := []*fnv1.Resource{}
+		for i := range candidates {
+			if !matchesSelector(sel, &candidates[i]) {
+				continue
+			}
+			s, err := structpb.NewStruct(candidates[i].Object)
+			if err != nil {
+				return nil, errors.Wrapf(err, "cannot convert resource %q", candidates[i].GetName())
+			}
+			items = append(items, &fnv1.Resource{Resource: s})
+		}
+		required[key] = &fnv1.Resources{Items: items}
+	}
+	return required, nil
+}
+
+// Returns true if the resource is selected by the selector. Selectors without a
+// namespace match resources in any namespace.
+func matchesSelector(sel *fnv1.ResourceSelector, u *unstructured.Unstructured) bool {
+	if u.GetAPIVersion() != sel.GetApiVersion() || u.GetKind() != sel.GetKind() {
+		return false
+	}
+	if ns := sel.GetNamespace(); ns != "" && u.GetNamespace() != ns {
+		return false
+	}
+	if ml := sel.GetMatchLabels(); ml != nil {
+		return labels.SelectorFromSet(ml.GetLabels()).Matches(labels.Set(u.GetLabels()))
+	}
+	return u.GetName() == sel.GetMatchName()
+}
+
+// Read the candidate resources from files and directories of YAML files.
+func readCandidates(paths []string) ([]unstructured.Unstructured, error) {
+	var candidates []unstructured.Unstructured
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			// Only files that were named explicitly are read regardless of
+			// their extension.
+			if path != root {
+				switch filepath.Ext(path) {
+				case ".yaml", ".yml", ".json":
+				default:
+					return nil
+				}
+			}
+			docs, err := readDocuments(path)
+			if err != nil {
+				return errors.Wrapf(err, "cannot read resources from %q", path)
+			}
+			for _, obj := range docs {
+				candidates = append(candidates, unstructured.Unstructured{Object: obj})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return candidates, nil
+}
+
+// Return a human readable name of a result severity.
+func severityName(s fnv1.Severity) string {
+	switch s { //nolint:exhaustive // Unspecified results are reported as such.
+	case fnv1.Severity_SEVERITY_FATAL:
+		return "Fatal"
+	case fnv1.Severity_SEVERITY_WARNING:
+		return "Warning"
+	case fnv1.Severity_SEVERITY_NORMAL:
+		return "Normal"
+	default:
+		return "Unspecified"
+	}
+}
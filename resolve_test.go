@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/logging"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/ptr"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+func TestSimulate(t *testing.T) {
+	envConfig := func(name string, labels map[string]any, data map[string]any) unstructured.Unstructured {
+		return unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "apiextensions.crossplane.io/v1beta1",
+			"kind":       "EnvironmentConfig",
+			"metadata":   map[string]any{"name": name, "labels": labels},
+			"data":       data,
+		}}
+	}
+
+	type args struct {
+		input      string
+		candidates []unstructured.Unstructured
+	}
+	type want struct {
+		context map[string]any
+		err     error
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"ChainedLookup": {
+			reason: "Sources drawing values from other sources should be resolved over multiple iterations.",
+			args: args{
+				input: `{
+					"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"spec": {
+						"extraResources": [
+							{
+								"kind": "EnvironmentConfig",
+								"apiVersion": "apiextensions.crossplane.io/v1beta1",
+								"into": "team",
+								"outputShape": "Object",
+								"fields": ["metadata.name"],
+								"ref": {
+									"name": "platform"
+								}
+							},
+							{
+								"type": "Selector",
+								"kind": "EnvironmentConfig",
+								"apiVersion": "apiextensions.crossplane.io/v1beta1",
+								"into": "envs",
+								"fields": ["metadata.name"],
+								"selector": {
+									"matchLabels": [
+										{
+											"key": "team",
+											"type": "FromExtraResourceFieldPath",
+											"fromExtraResource": "team",
+											"valueFromFieldPath": "data.team"
+										}
+									]
+								}
+							}
+						]
+					}
+				}`,
+				candidates: []unstructured.Unstructured{
+					envConfig("platform", nil, map[string]any{"team": "platform"}),
+					envConfig("dev", map[string]any{"team": "platform"}, nil),
+					envConfig("prod", map[string]any{"team": "platform"}, nil),
+					envConfig("other", map[string]any{"team": "other"}, nil),
+				},
+			},
+			want: want{
+				context: map[string]any{
+					"apiextensions.crossplane.io/extra-resources": map[string]any{
+						"team": map[string]any{"metadata": map[string]any{"name": "platform"}},
+						"envs": []any{
+							map[string]any{"metadata": map[string]any{"name": "dev"}},
+							map[string]any{"metadata": map[string]any{"name": "prod"}},
+						},
+					},
+				},
+			},
+		},
+		"AllSourcesSkipped": {
+			reason: "An input whose sources are all skipped should be resolved although nothing is ever required.",
+			args: args{
+				input: `{
+					"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"spec": {
+						"extraResources": [
+							{
+								"kind": "EnvironmentConfig",
+								"apiVersion": "apiextensions.crossplane.io/v1beta1",
+								"into": "vpc",
+								"ref": {
+									"name": "shared-vpc"
+								},
+								"when": {
+									"type": "FieldPathExists",
+									"fieldPath": "spec.networking"
+								}
+							}
+						]
+					}
+				}`,
+				candidates: []unstructured.Unstructured{
+					envConfig("shared-vpc", nil, nil),
+				},
+			},
+			want: want{
+				context: map[string]any{
+					"apiextensions.crossplane.io/extra-resources": map[string]any{},
+				},
+			},
+		},
+		"Fatal": {
+			reason: "A fatal result should be returned as an error.",
+			args: args{
+				input: `{
+					"apiVersion": "extra-resources.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"spec": {
+						"extraResources": [
+							{
+								"kind": "EnvironmentConfig",
+								"apiVersion": "apiextensions.crossplane.io/v1beta1",
+								"into": "missing",
+								"ref": {
+									"name": "missing"
+								}
+							}
+						]
+					}
+				}`,
+			},
+			want: want{
+				err: cmpopts.AnyError,
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := &fnv1.RunFunctionRequest{
+				Meta: &fnv1.RequestMeta{Tag: "resolve"},
+				Observed: &fnv1.State{
+					Composite: &fnv1.Resource{
+						Resource: resource.MustStructJSON(`{
+							"apiVersion": "test.crossplane.io/v1alpha1",
+							"kind": "XR",
+							"metadata": {
+								"name": "my-xr"
+							}
+						}`),
+					},
+				},
+				Desired: &fnv1.State{},
+				Input:   resource.MustStructJSON(tc.args.input),
+			}
+			rsp, err := simulate(context.Background(), &Function{log: logging.NewNopLogger()}, req, tc.args.candidates)
+			if diff := cmp.Diff(tc.want.err, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("%s\nsimulate(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tc.want.context, rsp.GetContext().AsMap()); diff != "" {
+				t.Errorf("%s\nsimulate(...): -want context, +got context:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestMatchesSelector(t *testing.T) {
+	u := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "apiextensions.crossplane.io/v1beta1",
+		"kind":       "EnvironmentConfig",
+		"metadata": map[string]any{
+			"name":      "dev",
+			"namespace": "team-a",
+			"labels":    map[string]any{"env": "dev"},
+		},
+	}}
+
+	cases := map[string]struct {
+		reason string
+		sel    *fnv1.ResourceSelector
+		want   bool
+	}{
+		"MatchName": {
+			reason: "Resources should be matched by name",
+			sel: &fnv1.ResourceSelector{
+				ApiVersion: "apiextensions.crossplane.io/v1beta1",
+				Kind:       "EnvironmentConfig",
+				Match:      &fnv1.ResourceSelector_MatchName{MatchName: "dev"},
+			},
+			want: true,
+		},
+		"MatchLabels": {
+			reason: "Resources should be matched by labels",
+			sel: &fnv1.ResourceSelector{
+				ApiVersion: "apiextensions.crossplane.io/v1beta1",
+				Kind:       "EnvironmentConfig",
+				Match:      &fnv1.ResourceSelector_MatchLabels{MatchLabels: &fnv1.MatchLabels{Labels: map[string]string{"env": "dev"}}},
+			},
+			want: true,
+		},
+		"OtherKind": {
+			reason: "Resources of another kind should not be matched",
+			sel: &fnv1.ResourceSelector{
+				ApiVersion: "v1",
+				Kind:       "ConfigMap",
+				Match:      &fnv1.ResourceSelector_MatchName{MatchName: "dev"},
+			},
+			want: false,
+		},
+		"OtherNamespace": {
+			reason: "Resources in another namespace should not be matched",
+			sel: &fnv1.ResourceSelector{
+				ApiVersion: "apiextensions.crossplane.io/v1beta1",
+				Kind:       "EnvironmentConfig",
+				Match:      &fnv1.ResourceSelector_MatchName{MatchName: "dev"},
+				Namespace:  ptr.To("team-b"),
+			},
+			want: false,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := matchesSelector(tc.sel, u); got != tc.want {
+				t.Errorf("%s\nmatchesSelector(...): want %t, got %t", tc.reason, tc.want, got)
+			}
+		})
+	}
+}
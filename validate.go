@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/fieldpath"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/crossplane-contrib/function-extra-resources/input/v1beta1"
+)
+
+const (
+	// inputGroup is the API group of the input of this Function.
+	inputGroup = "extra-resources.fn.crossplane.io"
+	// compositionGroup is the API group of Crossplane Compositions.
+	compositionGroup = "apiextensions.crossplane.io"
+)
+
+// ValidateCmd validates Function inputs offline.
+type ValidateCmd struct {
+	Files []string `arg:"" help:"YAML files containing Inputs of this Function, or Compositions whose pipelines use it." type:"existingfile"`
+}
+
+// A foundInput is an input of this Function found in a file.
+type foundInput struct {
+	// Source describes where the input was found, e.g. the step of a
+	// Composition pipeline.
+	Source string
+	Input  *v1beta1.Input
+}
+
+// Run validates all inputs found in the files, listing every problem.
+func (c *ValidateCmd) Run(k *kong.Context) error {
+	problems := 0
+	found := 0
+	for _, f := range c.Files {
+		inputs, err := readInputs(f)
+		if err != nil {
+			fmt.Fprintf(k.Stdout, "%s: %s\n", f, err)
+			problems++
+			continue
+		}
+		found += len(inputs)
+		for _, in := range inputs {
+			for _, err := range validateInput(in.Input) {
+				fmt.Fprintf(k.Stdout, "%s: %s: %s\n", f, in.Source, err)
+				problems++
+			}
+		}
+	}
+	if problems > 0 {
+		return errors.Errorf("found %d problems", problems)
+	}
+	fmt.Fprintf(k.Stdout, "No problems found (%d inputs)\n", found)
+	return nil
+}
+
+// Validate the input the same way the Function does, returning every problem.
+func validateInput(in *v1beta1.Input) []error {
+	var errs []error
+	if err := in.Validate(); err != nil {
+		var agg utilerrors.Aggregate
+		if errors.As(err, &agg) {
+			errs = append(errs, agg.Errors()...)
+		} else {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// Read all inputs of this Function from a file containing YAML documents.
+// Documents that are neither an input nor a Composition are skipped, and so are
+// the steps of a Composition pipeline that use other Functions.
+func readInputs(path string) ([]foundInput, error) {
+	docs, err := readDocuments(path)
+	if err != nil {
+		return nil, err
+	}
+	var inputs []foundInput
+	for i, obj := range docs {
+		in, err := inputsOf(obj)
+		if err != nil {
+			return nil, errors.Wrapf(err, "document %d", i)
+		}
+		inputs = append(inputs, in...)
+	}
+	return inputs, nil
+}
+
+// Read all YAML or JSON documents of a file. Empty documents are skipped.
+func readDocuments(path string) ([]map[string]any, error) {
+	f, err := os.Open(path) //nolint:gosec // Reading user supplied files is the point.
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot open file")
+	}
+	defer f.Close() //nolint:errcheck // Only read from.
+
+	var docs []map[string]any
+	d := yaml.NewYAMLOrJSONDecoder(f, 4096)
+	for i := 0; ; i++ {
+		obj := map[string]any{}
+		if err := d.Decode(&obj); err != nil {
+			if errors.Is(err, io.EOF) {
+				return docs, nil
+			}
+			return nil, errors.Wrapf(err, "cannot decode document %d", i)
+		}
+		if len(obj) > 0 {
+			docs = append(docs, obj)
+		}
+	}
+}
+
+// Return the inputs of this Function in a decoded YAML document.
+func inputsOf(obj map[string]any) ([]foundInput, error) {
+	p := fieldpath.Pave(obj)
+	apiVersion, _ := p.GetString("apiVersion")
+	kind, _ := p.GetString("kind")
+	name, _ := p.GetString("metadata.name")
+
+	switch {
+	case groupOf(apiVersion) == inputGroup:
+		in, err := toInput(obj)
+		if err != nil {
+			return nil, err
+		}
+		return []foundInput{{Source: fmt.Sprintf("%s %q", kind, name), Input: in}}, nil
+	case groupOf(apiVersion) == compositionGroup && kind == "Composition":
+		var pipeline []any
+		if err := p.GetValueInto("spec.pipeline", &pipeline); err != nil && !fieldpath.IsNotFound(err) {
+			return nil, errors.Wrap(err, "cannot get pipeline of Composition")
+		}
+		var inputs []foundInput
+		for i := range pipeline {
+			step, _ := p.GetString(fmt.Sprintf("spec.pipeline[%d].step", i))
+			input := map[string]any{}
+			if err := p.GetValueInto(fmt.Sprintf("spec.pipeline[%d].input", i), &input); err != nil {
+				continue
+			}
+			v, _ := input["apiVersion"].(string)
+			if groupOf(v) != inputGroup {
+				continue
+			}
+			in, err := toInput(input)
+			if err != nil {
+				return nil, errors.Wrapf(err, "step %q of Composition %q", step, name)
+			}
+			inputs = append(inputs, foundInput{Source: fmt.Sprintf("Composition %q step %q", name, step), Input: in})
+		}
+		return inputs, nil
+	}
+	return nil, nil
+}
+
+// Convert a decoded YAML document to an input.
+func toInput(obj map[string]any) (*v1beta1.Input, error) {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot marshal input")
+	}
+	in := &v1beta1.Input{}
+	return in, errors.Wrap(json.Unmarshal(b, in), "cannot unmarshal input")
+}
+
+// Return the group of an API version, e.g. "apps" for "apps/v1".
+func groupOf(apiVersion string) string {
+	group, _, _ := strings.Cut(apiVersion, "/")
+	return group
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/kong"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+func TestInputsOf(t *testing.T) {
+	type want struct {
+		sources []string
+		err     error
+	}
+
+	cases := map[string]struct {
+		reason string
+		doc    string
+		want   want
+	}{
+		"Input": {
+			reason: "A standalone input should be found",
+			doc: `
+apiVersion: extra-resources.fn.crossplane.io/v1beta1
+kind: Input
+metadata:
+  name: my-input
+spec:
+  extraResources:
+    - into: obj-0
+      kind: EnvironmentConfig
+      apiVersion: apiextensions.crossplane.io/v1beta1
+      ref:
+        name: my-env-config
+`,
+			want: want{
+				sources: []string{`Input "my-input"`},
+			},
+		},
+		"Composition": {
+			reason: "Only the inputs of pipeline steps using this Function should be found",
+			doc: `
+apiVersion: apiextensions.crossplane.io/v1
+kind: Composition
+metadata:
+  name: my-composition
+spec:
+  mode: Pipeline
+  pipeline:
+    - step: pull-extra-resources
+      functionRef:
+        name: function-extra-resources
+      input:
+        apiVersion: extra-resources.fn.crossplane.io/v1beta1
+        kind: Input
+        spec:
+          extraResources: []
+    - step: go-templating
+      functionRef:
+        name: function-go-templating
+      input:
+        apiVersion: gotemplating.fn.crossplane.io/v1beta1
+        kind: GoTemplate
+    - step: auto-ready
+      functionRef:
+        name: function-auto-ready
+`,
+			want: want{
+				sources: []string{`Composition "my-composition" step "pull-extra-resources"`},
+			},
+		},
+		"Unrelated": {
+			reason: "Documents that are neither inputs nor Compositions should be skipped",
+			doc: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: my-config-map
+`,
+		},
+		"InvalidInput": {
+			reason: "Inputs that cannot be unmarshalled should return an error",
+			doc: `
+apiVersion: extra-resources.fn.crossplane.io/v1beta1
+kind: Input
+spec:
+  extraResources: not-a-list
+`,
+			want: want{
+				err: cmpopts.AnyError,
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			obj := map[string]any{}
+			if err := yaml.Unmarshal([]byte(tc.doc), &obj); err != nil {
+				t.Fatalf("cannot unmarshal document: %s", err)
+			}
+			inputs, err := inputsOf(obj)
+			if diff := cmp.Diff(tc.want.err, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("%s\ninputsOf(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+			var sources []string
+			for _, in := range inputs {
+				sources = append(sources, in.Source)
+			}
+			if diff := cmp.Diff(tc.want.sources, sources); diff != "" {
+				t.Errorf("%s\ninputsOf(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestValidateCmdRun(t *testing.T) {
+	valid := `
+apiVersion: extra-resources.fn.crossplane.io/v1beta1
+kind: Input
+metadata:
+  name: my-input
+spec:
+  extraResources:
+    - into: obj-0
+      kind: EnvironmentConfig
+      apiVersion: apiextensions.crossplane.io/v1beta1
+      ref:
+        name: my-env-config
+`
+	invalid := `
+apiVersion: apiextensions.crossplane.io/v1
+kind: Composition
+metadata:
+  name: my-composition
+spec:
+  mode: Pipeline
+  pipeline:
+    - step: pull-extra-resources
+      functionRef:
+        name: function-extra-resources
+      input:
+        apiVersion: extra-resources.fn.crossplane.io/v1beta1
+        kind: Input
+        spec:
+          extraResources:
+            - into: obj-0
+              kind: EnvironmentConfig
+              apiVersion: apiextensions.crossplane.io/v1beta1
+              ref:
+                name: my-env-config
+            - into: obj-0
+              type: Selector
+              kind: EnvironmentConfig
+              apiVersion: apiextensions.crossplane.io/v1beta1
+              selector:
+                minMatch: 2
+                maxMatch: 1
+                matchLabels:
+                  - key: team
+                    type: Value
+                    value: platform
+`
+
+	type want struct {
+		output string
+		err    string
+	}
+
+	cases := map[string]struct {
+		reason string
+		files  []string
+		want   want
+	}{
+		"Valid": {
+			reason: "Valid inputs should be counted and return no error",
+			files:  []string{valid},
+			want: want{
+				output: "No problems found (1 inputs)\n",
+			},
+		},
+		"Invalid": {
+			reason: "Every problem of the inputs of all files, including those of Composition steps, should be listed and returned as an error",
+			files:  []string{valid, invalid},
+			want: want{
+				output: `invalid.yaml: Composition "my-composition" step "pull-extra-resources": spec.extraResources[1].into: Duplicate value: "obj-0"` + "\n" +
+					`invalid.yaml: Composition "my-composition" step "pull-extra-resources": spec.extraResources[1].selector.minMatch: Invalid value: 2: minMatch must not be greater than maxMatch` + "\n",
+				err: "found 2 problems",
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			cmd := &ValidateCmd{}
+			for i, content := range tc.files {
+				file := "valid.yaml"
+				if i > 0 {
+					file = "invalid.yaml"
+				}
+				path := filepath.Join(dir, file)
+				if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+					t.Fatalf("cannot write file: %s", err)
+				}
+				cmd.Files = append(cmd.Files, path)
+			}
+
+			out := &bytes.Buffer{}
+			err := cmd.Run(&kong.Context{Kong: &kong.Kong{Stdout: out}})
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if diff := cmp.Diff(tc.want.err, got); diff != "" {
+				t.Errorf("%s\nRun(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.output, strings.ReplaceAll(out.String(), dir+string(filepath.Separator), "")); diff != "" {
+				t.Errorf("%s\nRun(...): -want output, +got output:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}